@@ -1,5 +1,7 @@
 package yarex_test
 
+//go:generate cmd/yarexgen/yarexgen api_test.go
+
 import (
 	"reflect"
 	"regexp"
@@ -11,12 +13,16 @@ import (
 func testAPIs(t *testing.T, restr string, tests []string) {
 	stdRe := regexp.MustCompile(restr)
 	opRe := yarex.MustCompileOp(restr)
+	cloRe := yarex.MustCompileClosure(restr)
 	compRe := yarex.MustCompile(restr)
 	for _, str := range tests {
 		r := stdRe.FindString(str)
 		if opRe.FindString(str) != r {
 			t.Errorf("(OpTree) %v.FindString(%q) returned %q, but expected %q", opRe, str, opRe.FindString(str), r)
 		}
+		if cloRe.FindString(str) != r {
+			t.Errorf("(Closure) %v.FindString(%q) returned %q, but expected %q", cloRe, str, cloRe.FindString(str), r)
+		}
 		if compRe.FindString(str) != r {
 			t.Errorf("(Compiled) %v.FindString(%q) returned %q, but expected %q", opRe, str, compRe.FindString(str), r)
 		}
@@ -24,6 +30,9 @@ func testAPIs(t *testing.T, restr string, tests []string) {
 		if !reflect.DeepEqual(opRe.FindStringIndex(str), loc) {
 			t.Errorf("(OpTree) %v.FindStringIndex(%q) returned %v, but expected %v", opRe, str, opRe.FindStringIndex(str), loc)
 		}
+		if !reflect.DeepEqual(cloRe.FindStringIndex(str), loc) {
+			t.Errorf("(Closure) %v.FindStringIndex(%q) returned %v, but expected %v", cloRe, str, cloRe.FindStringIndex(str), loc)
+		}
 		if !reflect.DeepEqual(compRe.FindStringIndex(str), loc) {
 			t.Errorf("(Compiled) %v.FindStringIndex(%q) returned %v, but expected %v", opRe, str, compRe.FindStringIndex(str), loc)
 		}
@@ -196,3 +205,70 @@ func TestAPI(t *testing.T) {
 		"\"0333334444\"<sip:[2001:30:fe::4:123]>;user=phone",
 	})
 }
+
+func TestMatchBuffer(t *testing.T) {
+	ptn := "([a-z]+)(?:=([0-9]+))?|(@)" //yarexgen
+	std := regexp.MustCompile(ptn)
+	res := map[string]*yarex.Regexp{
+		"OpTree":   yarex.MustCompileOp(ptn),
+		"Closure":  yarex.MustCompileClosure(ptn),
+		"Compiled": yarex.MustCompile(ptn),
+	}
+	if !yarex.IsCompiledMatcher(res["Compiled"]) {
+		t.Errorf("%v should be Compiled matcher, but isn't", res["Compiled"])
+	}
+	var m yarex.Match
+	for name, re := range res {
+		for _, str := range []string{"key=123", "--abc", "1=2@", "", "x=y"} {
+			want := std.FindStringSubmatchIndex(str)
+			if ok := re.FindStringMatch(str, &m); ok != (want != nil) || !reflect.DeepEqual(m.Index(), want) {
+				t.Errorf("(%s) %v.FindStringMatch(%q) returned %t with %v, but expected %v", name, re, str, ok, m.Index(), want)
+			}
+			for i := 0; i <= re.NumSubexp(); i++ {
+				s := ""
+				if want != nil && want[2*i] >= 0 {
+					s = str[want[2*i]:want[2*i+1]]
+				}
+				if g := m.Group(i); g != s {
+					t.Errorf("(%s) Group(%d) of %v against %q returned %q, but expected %q", name, i, re, str, g, s)
+				}
+			}
+		}
+		if !re.FindStringMatchAt("--abc=1", 2, &m) || m.Group(0) != "abc=1" || m.Group(2) != "1" {
+			t.Errorf("(%s) %v.FindStringMatchAt should match abc=1, but got %v", name, re, m.Index())
+		}
+		if re.FindStringMatchAt("--abc=1", 1, &m) || m.Index() != nil || m.NumGroup() != 0 {
+			t.Errorf("(%s) %v.FindStringMatchAt should not match at 1, but got %v", name, re, m.Index())
+		}
+	}
+}
+
+func TestMatchBufferNoAlloc(t *testing.T) {
+	if !yarex.UsesUnsafe {
+		t.Skip("matching allocates memory without unsafe")
+	}
+	res := map[string]*yarex.Regexp{
+		"OpTree":      yarex.MustCompileOp(`(\w+)@(\w+)\.com`),
+		"Closure":     yarex.MustCompileClosure(`(\w+)@(\w+)\.com`),
+		"Compiled":    yarex.MustCompile(`(\w+)@(\w+)\.com`), //yarexgen
+		"OnePass":     yarex.MustCompileOnePass(`^(\w+)@(\w+)\.com`),
+		"BitParallel": yarex.MustCompileBitParallel(`a[bc]d.e`),
+	}
+	if !yarex.IsCompiledMatcher(res["Compiled"]) {
+		t.Errorf("%v should be Compiled matcher, but isn't", res["Compiled"])
+	}
+	var m yarex.Match
+	for name, re := range res {
+		str := "mail to: someone@example.com, abdxe"
+		if name == "OnePass" {
+			str = str[9:]
+		}
+		re.FindStringMatch(str, &m) // Grow m in advance.
+		if n := testing.AllocsPerRun(100, func() { re.FindStringMatch(str, &m) }); n != 0 {
+			t.Errorf("(%s) FindStringMatch allocated %v times per run", name, n)
+		}
+		if n := testing.AllocsPerRun(100, func() { re.MatchString(str) }); n != 0 {
+			t.Errorf("(%s) MatchString allocated %v times per run", name, n)
+		}
+	}
+}
@@ -3,7 +3,6 @@ package yarex
 import (
 	"strings"
 	"unicode/utf8"
-	"unsafe"
 )
 
 func astMatch(re Ast, s string) bool {
@@ -21,7 +20,7 @@ func astMatch(re Ast, s string) bool {
 	if canOnlyMatchAtBegining(re) {
 		return false
 	}
-	for i := 1; i < len(s); i++ {
+	for i := nextStart(s, 0); i < len(s); i = nextStart(s, i) {
 		if re.match(c0.push(0, i), i, func(c matchContext, _ int) *matchContext { return &c }) != nil {
 			return true
 		}
@@ -30,7 +29,7 @@ func astMatch(re Ast, s string) bool {
 }
 
 func (re AstLit) match(c matchContext, p int, k Continuation) *matchContext {
-	str := *(*string)(unsafe.Pointer(c.str))
+	str := c.input()
 	lit := string(re)
 	if !strings.HasPrefix(str[p:], lit) {
 		return nil
@@ -38,12 +37,21 @@ func (re AstLit) match(c matchContext, p int, k Continuation) *matchContext {
 	return k(c, p+len(lit))
 }
 
+func (re AstFoldLit) match(c matchContext, p int, k Continuation) *matchContext {
+	str := c.input()
+	if p = MatchFoldLit(str, p, string(re)); p < 0 {
+		return nil
+	}
+	return k(c, p)
+}
+
 func (re AstNotNewline) match(c matchContext, p int, k Continuation) *matchContext {
-	str := *(*string)(unsafe.Pointer(c.str))
-	if !(p < len(str)) || str[0] == '\n' {
+	str := c.input()
+	r, size := utf8.DecodeRuneInString(str[p:])
+	if size == 0 || r == '\n' {
 		return nil
 	}
-	return k(c, p+1)
+	return k(c, p+size)
 }
 
 func (r *AstSeq) match(c matchContext, p int, k Continuation) *matchContext {
@@ -70,7 +78,7 @@ func (r *AstAlt) match(c matchContext, p int, k Continuation) *matchContext {
 }
 
 func (r *AstRepeat) match(c matchContext, p int, k Continuation) *matchContext {
-	str := *(*string)(unsafe.Pointer(c.str))
+	str := c.input()
 	switch re := r.re.(type) {
 	case AstLit:
 		s := string(re)
@@ -185,7 +193,7 @@ func (re AstAssertBegin) match(c matchContext, p int, k Continuation) *matchCont
 }
 
 func (re AstAssertEnd) match(c matchContext, p int, k Continuation) *matchContext {
-	str := *(*string)(unsafe.Pointer(c.str))
+	str := c.input()
 	if p != len(str) {
 		return nil
 	}
@@ -193,7 +201,7 @@ func (re AstAssertEnd) match(c matchContext, p int, k Continuation) *matchContex
 }
 
 func (re AstCharClass) match(c matchContext, p int, k Continuation) *matchContext {
-	str := *(*string)(unsafe.Pointer(c.str))
+	str := c.input()
 	if len(str) < p+1 {
 		return nil
 	}
@@ -3,12 +3,17 @@ package yarex
 import (
 	"bytes"
 	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Here, we use uintpointer to pass *matchContext
 // to avoid from allocating the parameter in heap
 type Continuation = func(matchContext, int) *matchContext
 
+// Ast is an abstract syntax tree of a regular expression.
+// String() returns a pattern which is parsed back into an equivalent Ast.
 type Ast interface {
 	//Compile()
 	String() string
@@ -18,7 +23,17 @@ type Ast interface {
 type AstLit string
 
 func (re AstLit) String() string {
-	return string(re)
+	return quoteLit(string(re))
+}
+
+// AstFoldLit is a literal matched ignoring case, i.e. under simple case folding.
+type AstFoldLit string
+
+func (re AstFoldLit) String() string {
+	if len(re) == 0 {
+		return "(?:)"
+	}
+	return "(?i:" + quoteLit(string(re)) + ")"
 }
 
 type AstSeq struct {
@@ -40,15 +55,26 @@ type AstAlt struct {
 
 func (re *AstAlt) String() string {
 	b := bytes.NewBufferString("(?:")
-	fmt.Fprint(b, re.opts[0].String())
-	for _, r := range re.opts[1:] {
-		fmt.Fprint(b, "|")
-		fmt.Fprint(b, r.String())
-	}
+	re.writeOpts(b)
 	fmt.Fprint(b, ")")
 	return b.String()
 }
 
+// writeOpts writes the options separated by '|'. Nested AstAlts are flattened,
+// since the parser nests them to the right, e.g. a|b|c is parsed as a|(?:b|c).
+func (re *AstAlt) writeOpts(b *bytes.Buffer) {
+	for i, r := range re.opts {
+		if i > 0 {
+			fmt.Fprint(b, "|")
+		}
+		if alt, ok := r.(*AstAlt); ok {
+			alt.writeOpts(b)
+		} else {
+			fmt.Fprint(b, r.String())
+		}
+	}
+}
+
 type AstNotNewline struct{}
 
 func (re AstNotNewline) String() string {
@@ -61,37 +87,51 @@ type AstRepeat struct {
 }
 
 func (re *AstRepeat) String() string {
+	body := re.re.String()
+	switch r := re.re.(type) {
+	case AstLit:
+		if utf8.RuneCountInString(string(r)) != 1 {
+			body = "(?:" + body + ")"
+		}
+	case *AstRepeat, AstAssertBegin, AstAssertEnd:
+		body = "(?:" + body + ")"
+	}
 	if re.min == 0 && re.max == 1 {
-		return re.re.String() + "?"
+		return body + "?"
 	}
 	if re.min == 0 && re.max < 0 {
-		return re.re.String() + "*"
+		return body + "*"
 	}
 	if re.min == 1 && re.max < 0 {
-		return re.re.String() + "+"
+		return body + "+"
 	}
 	if re.min == re.max {
-		return fmt.Sprintf("%s{%d}", re.re.String(), re.min)
+		return fmt.Sprintf("%s{%d}", body, re.min)
 	}
 	if re.max < 0 {
-		return fmt.Sprintf("%s{%d,}", re.re.String(), re.min)
+		return fmt.Sprintf("%s{%d,}", body, re.min)
 	}
-	return fmt.Sprintf("%s{%d,%d}", re.re.String(), re.min, re.max)
+	return fmt.Sprintf("%s{%d,%d}", body, re.min, re.max)
 }
 
 type AstCap struct {
 	index uint
 	re    Ast
+	name  string // empty if the group is not named
 }
 
 func (re *AstCap) String() string {
+	if re.name != "" {
+		return fmt.Sprintf("(?P<%s>%s)", re.name, re.re)
+	}
 	return fmt.Sprintf("(%s)", re.re)
 }
 
 type AstBackRef uint
 
 func (re AstBackRef) String() string {
-	return fmt.Sprintf("\\%d", uint(re))
+	// Enclosed not to be followed by digits, e.g. \1 followed by 0.
+	return fmt.Sprintf("(?:\\%d)", uint(re))
 }
 
 type AstAssertBegin struct{}
@@ -112,5 +152,30 @@ type AstCharClass struct {
 }
 
 func (re AstCharClass) String() string {
-	return "[" + re.CharClass.String() + "]"
+	return re.CharClass.String()
+}
+
+// quoteLit escapes the metacharacters and non-printable characters in lit.
+func quoteLit(lit string) string {
+	var buf strings.Builder
+	for _, r := range lit {
+		buf.WriteString(quoteRune(r, false))
+	}
+	return buf.String()
+}
+
+// quoteRune returns r escaped if necessary. Escaped characters differ in and
+// out of a bracket expression, which is specified by inClass.
+func quoteRune(r rune, inClass bool) string {
+	if !unicode.IsPrint(r) || r == utf8.RuneError {
+		return fmt.Sprintf("\\x{%x}", r)
+	}
+	meta := `\.+*?()|[]{}^$`
+	if inClass {
+		meta = `\[]^-&`
+	}
+	if strings.ContainsRune(meta, r) {
+		return `\` + string(r)
+	}
+	return string(r)
 }
@@ -0,0 +1,109 @@
+package yarex
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAstString(t *testing.T) {
+	tests := []struct{ ptn, want string }{
+		{`a\.b*`, `(?:a\.b*)`},
+		{`(?:ab)*`, `(?:ab)*`},
+		{`(a)\1`, `(?:(a)(?:\1))`},
+		{`x{2,}`, `x{2,}`},
+		{`(?<x>a)(?P<y_1>b)`, `(?:(?P<x>a)(?P<y_1>b))`},
+		{`^*`, `(?:^)*`},
+		{`[^\d]`, `[^0-9]`},
+		{`[\w--[aeiou]]`, `[0-9A-Z_b-df-hj-np-tv-z]`},
+		{`[-^&\]\[]`, `[\&\-\[\]\^]`},
+		{`[\x{0}-\x{1f}\x7f]`, `[\x{0}-\x{1f}\x{7f}]`},
+		{`[^\x{0}-\x{10ffff}]`, `[^\x{0}-\x{10ffff}]`},
+		{`[a-z\x{10ffff}]`, `[^\x{0}-` + "`" + `{-\x{10fffe}]`},
+		{`\x41\x{3042}`, `(?:Aあ)`},
+		{`(?i:k+)`, `(?i:k)+`},
+		{`(?i)[a-c]`, `(?:(?:)[A-Ca-c])`},
+	}
+	for _, test := range tests {
+		ast, err := parse(test.ptn)
+		if err != nil {
+			t.Fatalf("parse(%q) returned error: %v", test.ptn, err)
+		}
+		if s := ast.String(); s != test.want {
+			t.Errorf("parse(%q).String() should be %q, but got %q", test.ptn, test.want, s)
+		}
+	}
+}
+
+// TestAstStringRoundTrip is a fuzz test for Ast.String(). It generates random
+// patterns and checks that their string representations are parsed back into
+// Asts matching the same strings, and that the representations are stable
+// after a round trip.
+func TestAstStringRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 3000; i++ {
+		ptn := randomPattern(r, 3)
+		ast, err := parse(ptn)
+		if err != nil {
+			t.Fatalf("parse(%q) returned error: %v", ptn, err)
+		}
+		for _, ast := range []Ast{ast, optimizeAst(ast)} {
+			s := ast.String()
+			reparsed, err := parse(s)
+			if err != nil {
+				t.Fatalf("%q is printed as %q, but it cannot be parsed: %v", ptn, s, err)
+			}
+			s2 := reparsed.String()
+			if again, err := parse(s2); err != nil || again.String() != s2 {
+				t.Fatalf("%q is printed as %q and then %q, which is not stable", ptn, s, s2)
+			}
+			want := &Regexp{ptn, newClosureExecer(optimizeAst(ast), false), numSubexp(ast)}
+			got := &Regexp{s, newClosureExecer(optimizeAst(reparsed), false), numSubexp(reparsed)}
+			for j := 0; j < 20; j++ {
+				str := randomString(r)
+				if w, g := want.FindStringIndex(str), got.FindStringIndex(str); !reflect.DeepEqual(w, g) {
+					t.Fatalf("%q is printed as %q, and they match %q differently: want %v, but got %v", ptn, s, str, w, g)
+				}
+			}
+		}
+	}
+}
+
+var randomAtoms = []string{
+	"a", "b", "k", "é", "あ", `\.`, `\*`, `\\`, `\[`, `\x{0}`, `\x7f`, `\-`,
+	".", `\d`, `\S`, `\w`, "[a-c]", "[^a]", "[]a]", "[a-]", `[\-^&]`, "[^\n]",
+	`[\w&&[^5]]`, `[a-z--[aeiou]]`, `[\x{0}-\x{10ffff}]`, "[α-ω]",
+	"^", "$",
+}
+
+func randomPattern(r *rand.Rand, depth int) string {
+	var buf strings.Builder
+	for n := 1 + r.Intn(4); n > 0; n-- {
+		if depth > 0 && r.Intn(4) == 0 {
+			group := []string{"(", "(?:", "(?i:", "(?i)"}[r.Intn(4)]
+			buf.WriteString(group)
+			buf.WriteString(randomPattern(r, depth-1))
+			if group == "(?i)" { // The flag affects the rest, and cannot be quantified.
+				continue
+			}
+			buf.WriteString(")")
+		} else {
+			buf.WriteString(randomAtoms[r.Intn(len(randomAtoms))])
+		}
+		buf.WriteString([]string{"", "", "", "?", "*", "+", "{2}", "{0,2}", "{1,}"}[r.Intn(9)])
+	}
+	if depth > 0 && r.Intn(4) == 0 {
+		buf.WriteString("|")
+		buf.WriteString(randomPattern(r, depth-1))
+	}
+	return buf.String()
+}
+
+func randomString(r *rand.Rand) string {
+	var buf strings.Builder
+	for n := r.Intn(8); n > 0; n-- {
+		buf.WriteString([]string{"a", "b", "A", "k", "K", "é", "É", "あ", ".", "\x00", "5", "-", "\n", "α"}[r.Intn(14)])
+	}
+	return buf.String()
+}
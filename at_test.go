@@ -0,0 +1,95 @@
+package yarex_test
+
+//go:generate cmd/yarexgen/yarexgen at_test.go
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/Maki-Daisuke/go-yarex"
+)
+
+func testAnchoredAPIs(t *testing.T, ptn string, tests []string) {
+	stdAt := regexp.MustCompile(`\A(?:` + ptn + `)`)
+	stdFull := regexp.MustCompile(`^(?:` + ptn + `)$`)
+	res := map[string]*yarex.Regexp{
+		"OpTree":   yarex.MustCompileOp(ptn),
+		"Closure":  yarex.MustCompileClosure(ptn),
+		"Compiled": yarex.MustCompile(ptn),
+	}
+	if !yarex.IsCompiledMatcher(res["Compiled"]) {
+		t.Errorf("%v should be Compiled matcher, but isn't", res["Compiled"])
+	}
+	for name, re := range res {
+		for _, str := range tests {
+			for pos := 0; pos <= len(str); pos++ {
+				want := stdAt.FindStringIndex(str[pos:])
+				if want != nil {
+					want[0] += pos
+					want[1] += pos
+				}
+				if loc := re.FindStringIndexAt(str, pos); !reflect.DeepEqual(loc, want) {
+					t.Errorf("(%s) %v.FindStringIndexAt(%q, %d) returned %v, but expected %v", name, re, str, pos, loc, want)
+				}
+				want = stdAt.FindStringSubmatchIndex(str[pos:])
+				for i := range want {
+					if want[i] >= 0 {
+						want[i] += pos
+					}
+				}
+				if loc := re.FindStringSubmatchIndexAt(str, pos); !reflect.DeepEqual(loc, want) {
+					t.Errorf("(%s) %v.FindStringSubmatchIndexAt(%q, %d) returned %v, but expected %v", name, re, str, pos, loc, want)
+				}
+				if m := re.MatchStringAt(str, pos); m != (want != nil) {
+					t.Errorf("(%s) %v.MatchStringAt(%q, %d) returned %t, but expected %t", name, re, str, pos, m, want != nil)
+				}
+			}
+			if m, want := re.FullMatchString(str), stdFull.MatchString(str); m != want {
+				t.Errorf("(%s) %v.FullMatchString(%q) returned %t, but expected %t", name, re, str, m, want)
+			}
+		}
+	}
+}
+
+func TestMatchAt(t *testing.T) {
+	re := "foo|foobar" //yarexgen
+	testAnchoredAPIs(t, re, []string{"foobar", "xfoo", "foofoobar", ""})
+
+	re = "[a-z]+[0-9]*" //yarexgen
+	testAnchoredAPIs(t, re, []string{"abc123", "12ab3", "a", "あa1"})
+
+	re = "(a|ab)(c|bcd)(d*)" //yarexgen
+	testAnchoredAPIs(t, re, []string{"abcd", "xabcdd", "abcdabcd"})
+
+	re = "x*" //yarexgen
+	testAnchoredAPIs(t, re, []string{"", "xx", "axx"})
+
+	re = "b$" //yarexgen
+	testAnchoredAPIs(t, re, []string{"abb", "ba"})
+}
+
+func TestMatchAtBegin(t *testing.T) {
+	re := yarex.MustCompile("^ab") //yarexgen
+	if !re.MatchStringAt("abab", 0) {
+		t.Errorf("%v should match at 0, but did not", re)
+	}
+	if re.MatchStringAt("abab", 2) {
+		t.Errorf("%v should not match at 2, since ^ matches only at the beginning of string", re)
+	}
+	re = yarex.MustCompile("^a(b|c)d?") //yarexgen
+	if !re.FullMatchString("acd") || !re.FullMatchString("ab") || re.FullMatchString("abdx") {
+		t.Errorf("%v.FullMatchString returned wrong results", re)
+	}
+	if n := re.NumSubexp(); n != 1 {
+		t.Errorf("%v.NumSubexp() should be 1, but got %d", re, n)
+	}
+	for _, pos := range []int{-1, 5} {
+		if re.MatchStringAt("abd", pos) || re.FindStringIndexAt("abd", pos) != nil {
+			t.Errorf("%v should not match at out-of-range position %d", re, pos)
+		}
+	}
+	if s := yarex.MustCompile("[0-9]+").FindStringAt("ab123c", 2); s != "123" {
+		t.Errorf("want %q, but got %q", "123", s)
+	}
+}
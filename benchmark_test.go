@@ -3,6 +3,7 @@ package yarex_test
 import (
 	"os"
 	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/Maki-Daisuke/go-yarex"
@@ -16,6 +17,7 @@ var sipReStd = regexp.MustCompile(sipPattern)
 var sipReAst, _ = yarex.Parse(sipPattern)
 var sipReOpt = yarex.OptimizeAst(sipReAst)
 var sipReOp = yarex.MustCompileOp(sipPattern)
+var sipReClosure = yarex.MustCompileClosure(sipPattern)
 
 // Initialize sipReComp in TestMain, because it must be initialized after RegisterCompiledRegexp is called.
 var sipReComp *yarex.Regexp
@@ -64,6 +66,14 @@ func BenchmarkSipPattern_Optree(b *testing.B) {
 	}
 }
 
+func BenchmarkSipPattern_Closure(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		for _, s := range testStrings {
+			sipReClosure.MatchString(s)
+		}
+	}
+}
+
 func BenchmarkSipPattern_Compiled(b *testing.B) {
 	if !yarex.IsCompiledMatcher(sipReComp) {
 		panic("Not compiled!!!!!")
@@ -74,3 +84,148 @@ func BenchmarkSipPattern_Compiled(b *testing.B) {
 		}
 	}
 }
+
+var shortPattern = `a[bc]d.e`
+var shortReStd = regexp.MustCompile(shortPattern)
+var shortReClosure = yarex.MustCompileClosure(shortPattern)
+var shortReBitParallel = yarex.MustCompileBitParallel(shortPattern)
+var shortText = "the quick brown fox jumps over the lazy dog; abd acd abdxe"
+
+func BenchmarkShortPattern_Standard(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		shortReStd.MatchString(shortText)
+	}
+}
+
+func BenchmarkShortPattern_Closure(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		shortReClosure.MatchString(shortText)
+	}
+}
+
+func BenchmarkShortPattern_BitParallel(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		shortReBitParallel.MatchString(shortText)
+	}
+}
+
+// Benchmarks of finding submatches, which report allocations to compare the
+// reusable Match with the APIs returning a new slice.
+
+func BenchmarkSipSubmatch_Standard(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, s := range testStrings {
+			sipReStd.FindStringSubmatchIndex(s)
+		}
+	}
+}
+
+func BenchmarkSipSubmatch_Closure(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, s := range testStrings {
+			yarex.FindStringSubmatchIndex(sipReClosure, s)
+		}
+	}
+}
+
+func BenchmarkSipSubmatch_ClosureMatch(b *testing.B) {
+	b.ReportAllocs()
+	var m yarex.Match
+	for i := 0; i < b.N; i++ {
+		for _, s := range testStrings {
+			sipReClosure.FindStringMatch(s, &m)
+		}
+	}
+}
+
+func BenchmarkSipSubmatch_Compiled(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, s := range testStrings {
+			yarex.FindStringSubmatchIndex(sipReComp, s)
+		}
+	}
+}
+
+func BenchmarkSipSubmatch_CompiledMatch(b *testing.B) {
+	b.ReportAllocs()
+	var m yarex.Match
+	for i := 0; i < b.N; i++ {
+		for _, s := range testStrings {
+			sipReComp.FindStringMatch(s, &m)
+		}
+	}
+}
+
+func BenchmarkShortIndex_Closure(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		shortReClosure.FindStringIndex(shortText)
+	}
+}
+
+func BenchmarkShortIndex_ClosureMatch(b *testing.B) {
+	b.ReportAllocs()
+	var m yarex.Match
+	for i := 0; i < b.N; i++ {
+		shortReClosure.FindStringMatch(shortText, &m)
+	}
+}
+
+// Benchmarks of scanning long runs of character classes, which are dominated by
+// checking membership of each character.
+var classPattern = `[\w.+-]+@[\w-]+\.[a-zA-Z]{2,}[^\x00-\x20]*;` //yarexgen
+var classReStd = regexp.MustCompile(classPattern)
+var classReClosure = yarex.MustCompileClosure(classPattern)
+var classText = strings.Repeat("some.body+tag@example.com/π ", 50) + "alice@example.org/αβγ;"
+
+var unicodeClassPattern = `[α-ωΑ-Ω]+[^α-ωΑ-Ω\s]+[α-ω]{3}` //yarexgen
+var unicodeClassReStd = regexp.MustCompile(unicodeClassPattern)
+var unicodeClassReClosure = yarex.MustCompileClosure(unicodeClassPattern)
+var unicodeClassText = strings.Repeat("αβγδεζ ΑΒΓ ηθικλμ ", 50) + "ΑΒΓδεζ日本語xyzαβγ"
+
+func BenchmarkClass_Standard(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		classReStd.MatchString(classText)
+	}
+}
+
+func BenchmarkClass_Closure(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		classReClosure.MatchString(classText)
+	}
+}
+
+func BenchmarkClass_Compiled(b *testing.B) {
+	re := yarex.MustCompile(classPattern)
+	if !yarex.IsCompiledMatcher(re) {
+		panic("Not compiled!!!!!")
+	}
+	for i := 0; i < b.N; i++ {
+		re.MatchString(classText)
+	}
+}
+
+func BenchmarkUnicodeClass_Standard(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		unicodeClassReStd.MatchString(unicodeClassText)
+	}
+}
+
+func BenchmarkUnicodeClass_Closure(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		unicodeClassReClosure.MatchString(unicodeClassText)
+	}
+}
+
+func BenchmarkUnicodeClass_Compiled(b *testing.B) {
+	re := yarex.MustCompile(unicodeClassPattern)
+	if !yarex.IsCompiledMatcher(re) {
+		panic("Not compiled!!!!!")
+	}
+	for i := 0; i < b.N; i++ {
+		re.MatchString(unicodeClassText)
+	}
+}
@@ -0,0 +1,210 @@
+package yarex
+
+import "unicode/utf8"
+
+// matcher is implemented by execers which can tell whether a pattern matches
+// a string faster than exec, without finding where it matches.
+type matcher interface {
+	match(str string) bool
+}
+
+// bitParallelExecer runs a bit-parallel automaton (Shift-And, the dual of
+// Shift-Or) for short patterns consisting only of characters, char classes
+// and their repetitions. Each bit of a state represents a position in the
+// pattern, and all positions are updated at once for each character.
+//
+// It only answers whether the pattern matches. To find where it matches,
+// exec filters out strings that do not match, and then runs fallback.
+type bitParallelExecer struct {
+	ascii    [utf8.RuneSelf]uint64 // positions which can match each ASCII character
+	nonASCII uint64                // positions which can match any non-ASCII characters
+	loop     uint64                // positions which can repeat infinitely
+	blocks   []uint64              // blocks of consecutive optional positions
+	final    uint64                // the last position
+	begin    bool                  // anchored at the beginning
+	end      bool                  // anchored at the end
+	fallback execer
+}
+
+// newBitParallelExecer returns nil if re cannot be run by a bit-parallel automaton.
+func newBitParallelExecer(re Ast, fallback execer) *bitParallelExecer {
+	var seq []Ast
+	if s, ok := re.(*AstSeq); ok {
+		seq = s.seq
+	} else {
+		seq = []Ast{re}
+	}
+	bp := &bitParallelExecer{fallback: fallback}
+	if len(seq) > 0 {
+		if _, ok := seq[0].(AstAssertBegin); ok {
+			bp.begin = true
+			seq = seq[1:]
+		}
+	}
+	if len(seq) > 0 {
+		if _, ok := seq[len(seq)-1].(AstAssertEnd); ok {
+			bp.end = true
+			seq = seq[:len(seq)-1]
+		}
+	}
+	n := uint(0) // number of positions
+	optional := uint64(0)
+	add := func(re Ast, opt, loop bool) bool {
+		if n >= 64 {
+			return false
+		}
+		bit := uint64(1) << n
+		switch r := re.(type) {
+		case AstLit:
+			c := string(r)[0]
+			if c >= utf8.RuneSelf {
+				return false
+			}
+			bp.ascii[c] |= bit
+		case AstNotNewline:
+			for c := range bp.ascii {
+				if c != '\n' {
+					bp.ascii[c] |= bit
+				}
+			}
+			bp.nonASCII |= bit
+		case AstCharClass:
+			switch r.CharClass.(type) {
+			case AsciiMaskClass:
+			case CompAsciiMaskClass:
+				bp.nonASCII |= bit
+			default:
+				return false
+			}
+			for c := range bp.ascii {
+				if r.Contains(rune(c)) {
+					bp.ascii[c] |= bit
+				}
+			}
+		default:
+			return false
+		}
+		if opt {
+			optional |= bit
+		}
+		if loop {
+			bp.loop |= bit
+		}
+		n++
+		return true
+	}
+	for _, x := range seq {
+		switch r := x.(type) {
+		case AstLit:
+			for i := 0; i < len(r); i++ {
+				if !add(AstLit(r[i:i+1]), false, false) {
+					return nil
+				}
+			}
+		case AstNotNewline, AstCharClass:
+			if !add(r, false, false) {
+				return nil
+			}
+		case *AstRepeat:
+			if lit, ok := r.re.(AstLit); ok && len(lit) != 1 {
+				return nil
+			}
+			for i := 0; i < r.min; i++ {
+				if !add(r.re, false, false) {
+					return nil
+				}
+			}
+			if r.max < 0 {
+				if r.min > 0 {
+					bp.loop |= uint64(1) << (n - 1) // x+ is x followed by the last x repeating
+				} else if !add(r.re, true, true) {
+					return nil
+				}
+			}
+			for i := r.min; i < r.max; i++ {
+				if !add(r.re, true, false) {
+					return nil
+				}
+			}
+		default:
+			return nil
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	bp.final = uint64(1) << (n - 1)
+	// Split optional positions into blocks of consecutive ones.
+	for optional != 0 {
+		low := optional & -optional
+		blk := optional & ^(optional + low) // consecutive bits from low
+		bp.blocks = append(bp.blocks, blk)
+		optional &^= blk
+	}
+	return bp
+}
+
+// closure sets positions reachable by skipping optional positions. empty
+// indicates the empty prefix of the pattern matches at the current position.
+func (bp *bitParallelExecer) closure(d uint64, empty bool) uint64 {
+	for _, blk := range bp.blocks {
+		in := (blk & -blk) >> 1 // the position just before the block
+		if in == 0 && empty {   // the block is at the head of the pattern
+			d |= blk
+			continue
+		}
+		x := d & (blk | in)
+		if x != 0 {
+			low := x & -x
+			d |= blk &^ (low - 1)
+		}
+	}
+	return d
+}
+
+func (bp *bitParallelExecer) match(str string) bool {
+	return bp.matchFrom(str, 0)
+}
+
+func (bp *bitParallelExecer) matchFrom(str string, pos int) bool {
+	if bp.begin && pos != 0 {
+		return false
+	}
+	d := bp.closure(0, true)
+	if d&bp.final != 0 && (!bp.end || pos == len(str)) {
+		return true
+	}
+	for p := pos; p < len(str); {
+		// A new match can start here unless anchored at the beginning.
+		var start uint64 = 1
+		if bp.begin && p != 0 {
+			start = 0
+		}
+		var mask uint64
+		if c := str[p]; c < utf8.RuneSelf {
+			mask = bp.ascii[c]
+			p++
+		} else {
+			// Invalid bytes are regarded as U+FFFD, which is also non-ASCII.
+			_, size := utf8.DecodeRuneInString(str[p:])
+			mask = bp.nonASCII
+			p += size
+		}
+		d = (d<<1|start)&mask | d&bp.loop&mask
+		d = bp.closure(d, !bp.begin)
+		if d&bp.final != 0 && (!bp.end || p == len(str)) {
+			return true
+		}
+		if bp.begin && d == 0 {
+			return false // No partial match remains, and a new one cannot start.
+		}
+	}
+	return false
+}
+
+func (bp *bitParallelExecer) exec(str string, pos int, anchored bool, onSuccess func(MatchContext)) bool {
+	if !anchored && !bp.matchFrom(str, pos) { // The filter scans, so it is only for unanchored matching.
+		return false
+	}
+	return bp.fallback.exec(str, pos, anchored, onSuccess)
+}
@@ -0,0 +1,71 @@
+package yarex_test
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/Maki-Daisuke/go-yarex"
+)
+
+func testBitParallel(t *testing.T, restr string, tests []string) {
+	stdRe := regexp.MustCompile(restr)
+	bpRe := yarex.MustCompileBitParallel(restr)
+	for _, str := range tests {
+		if bpRe.MatchString(str) != stdRe.MatchString(str) {
+			t.Errorf("(BitParallel) %v.MatchString(%q) should be %t, but actually not", bpRe, str, stdRe.MatchString(str))
+		}
+		loc := stdRe.FindStringIndex(str)
+		if !reflect.DeepEqual(bpRe.FindStringIndex(str), loc) {
+			t.Errorf("(BitParallel) %v.FindStringIndex(%q) returned %v, but expected %v", bpRe, str, bpRe.FindStringIndex(str), loc)
+		}
+	}
+}
+
+func TestBitParallel(t *testing.T) {
+	testBitParallel(t, "a[bc]d.e", []string{
+		"abdxe",
+		"xxacdあe",
+		"abd\ne",
+		"abde",
+		"",
+		"aacdde",
+	})
+	testBitParallel(t, "fo{2,4}h", []string{
+		"foh",
+		"fooh",
+		"foooooh",
+		"xfoooohx",
+		"fooofooooh",
+	})
+	testBitParallel(t, "a?b?c", []string{
+		"c",
+		"abc",
+		"bc",
+		"ac",
+		"ab",
+		"",
+	})
+	testBitParallel(t, "^x[0-9]+y*$", []string{
+		"x1",
+		"x123yyy",
+		"x",
+		"xy",
+		"ax1",
+		"x1y2",
+	})
+	testBitParallel(t, "[^a-z]*z", []string{
+		"z",
+		"ABCz",
+		"あいz",
+		"abz",
+		"",
+	})
+	testBitParallel(t, "^a?b?$", []string{
+		"",
+		"a",
+		"ab",
+		"b",
+		"ba",
+	})
+}
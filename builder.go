@@ -0,0 +1,107 @@
+package yarex
+
+import (
+	"fmt"
+	"unicode"
+)
+
+// The following functions build patterns without writing escaped strings by
+// hand. They return the same Ast as parse builds, and String() of the Ast is
+// a pattern which can be passed to Compile and GoGenerator.Add, e.g.:
+//
+//	p := yarex.Seq(yarex.Lit("v"), yarex.Repeat(yarex.Class('0', '9'), 1, -1))
+//	re := yarex.MustCompile(p.String()) // matches the same as `v[0-9]+`
+//
+// They panic for invalid arguments, since patterns are usually built at
+// initialization as MustCompile.
+
+// Lit returns a pattern matching s literally.
+func Lit(s string) Ast {
+	return AstLit(s)
+}
+
+// FoldLit returns a pattern matching s ignoring case, as (?i:s).
+func FoldLit(s string) Ast {
+	return foldLit(s)
+}
+
+// Seq returns a pattern matching concatenation of xs.
+func Seq(xs ...Ast) Ast {
+	switch len(xs) {
+	case 0:
+		return AstLit("")
+	case 1:
+		return xs[0]
+	}
+	return &AstSeq{append([]Ast(nil), xs...)}
+}
+
+// Alt returns a pattern matching any of xs, which are tried from left to
+// right. Alt() matches nothing.
+func Alt(xs ...Ast) Ast {
+	switch len(xs) {
+	case 0:
+		return Class()
+	case 1:
+		return xs[0]
+	}
+	return &AstAlt{append([]Ast(nil), xs...)}
+}
+
+// Repeat returns a pattern matching x from min to max times. Negative max means
+// unlimited, e.g. Repeat(x, 1, -1) is x+.
+func Repeat(x Ast, min, max int) Ast {
+	if min < 0 || max >= 0 && min > max {
+		panic(fmt.Errorf("invalid repeat count: {%d,%d}", min, max))
+	}
+	if max < 0 {
+		max = -1
+	}
+	return &AstRepeat{x, min, max}
+}
+
+// Capture returns a capturing group of x. The group is named unless name is
+// empty. Groups are numbered in the order of their appearance in the pattern.
+func Capture(name string, x Ast) Ast {
+	if name != "" && !isValidCaptureName(name) {
+		panic(fmt.Errorf("invalid group name: %q", name))
+	}
+	return &AstCap{0, x, name} // The index is assigned when String() is parsed.
+}
+
+// Class returns a pattern matching a character in ranges, which are pairs of
+// the lowest and highest characters, e.g. Class('0', '9', 'a', 'f').
+// Class() matches nothing.
+func Class(ranges ...rune) Ast {
+	if len(ranges)%2 != 0 {
+		panic(fmt.Errorf("odd number of runes in class ranges: %q", ranges))
+	}
+	rs := make([]runeRange, len(ranges)/2)
+	for i := range rs {
+		lo, hi := ranges[2*i], ranges[2*i+1]
+		if lo > hi || lo < 0 || hi > unicode.MaxRune {
+			panic(fmt.Errorf("invalid class range: %q-%q", lo, hi))
+		}
+		rs[i] = runeRange{lo, hi}
+	}
+	c := classOfRanges(unionRanges(rs))
+	if r, ok := singleCharOf(c); ok {
+		return AstLit(string(r))
+	}
+	return AstCharClass{c, c.String()}
+}
+
+// Dot returns a pattern matching any character except newline, i.e. '.'.
+func Dot() Ast {
+	return AstNotNewline{}
+}
+
+// Begin returns a pattern matching at beginning of text, i.e. '^'.
+func Begin() Ast {
+	return AstAssertBegin{}
+}
+
+// End returns a pattern matching at end of text, i.e. '$'.
+func End() Ast {
+	return AstAssertEnd{}
+}
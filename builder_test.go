@@ -0,0 +1,89 @@
+package yarex_test
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/Maki-Daisuke/go-yarex"
+)
+
+func TestBuilder(t *testing.T) {
+	Y := yarex.Lit
+	tests := []struct {
+		ast  yarex.Ast
+		want string
+		std  string
+	}{
+		{yarex.Seq(Y("1+1"), yarex.Repeat(Y("="), 1, -1), Y("2")), `(?:1\+1=+2)`, `1\+1=+2`},
+		{yarex.Alt(Y("foo"), Y("(bar)"), Y("")), `(?:foo|\(bar\)|)`, `foo|\(bar\)|`},
+		{yarex.Repeat(Y("ab"), 2, 3), `(?:ab){2,3}`, `(?:ab){2,3}`},
+		{yarex.Seq(yarex.Begin(), yarex.Repeat(yarex.Class('0', '9', 'a', 'f', 'A', 'F'), 1, -1), yarex.End()), `(?:^[0-9A-Fa-f]+$)`, `^[0-9A-Fa-f]+$`},
+		{yarex.Seq(yarex.Capture("key", yarex.Repeat(yarex.Class('a', 'z'), 1, -1)), Y("="), yarex.Capture("", yarex.Repeat(yarex.Dot(), 0, -1))), `(?:(?P<key>[a-z]+)=(.*))`, `(?P<key>[a-z]+)=(.*)`},
+		{yarex.Seq(yarex.FoldLit("Go"), yarex.Class('-', '-', '^', '^')), `(?:(?i:Go)[\-\^])`, `(?i:go)[-^]`},
+		{yarex.Seq(Y("a"), yarex.Alt(), Y("b")), `(?:a[^\x{0}-\x{10ffff}]b)`, `a[^\x00-\x{10ffff}]b`},
+		{yarex.Seq(), ``, ``},
+		{yarex.Class('x', 'x'), `x`, `x`},
+	}
+	for _, test := range tests {
+		ptn := test.ast.String()
+		if ptn != test.want {
+			t.Errorf("want %q, but got %q", test.want, ptn)
+		}
+		re := yarex.MustCompile(ptn)
+		std := regexp.MustCompile(test.std)
+		for _, str := range []string{"", "1+1=2", "1+1==2", "foo", "(bar)", "abab", "ababab", "Cafe", "key=value", "GO-", "go^", "ab"} {
+			if loc, want := re.FindStringIndex(str), std.FindStringIndex(str); !reflect.DeepEqual(loc, want) {
+				t.Errorf("%v.FindStringIndex(%q) returned %v, but expected %v", re, str, loc, want)
+			}
+		}
+	}
+}
+
+func TestBuilderPanic(t *testing.T) {
+	for _, f := range []func(){
+		func() { yarex.Repeat(yarex.Lit("a"), 2, 1) },
+		func() { yarex.Repeat(yarex.Lit("a"), -1, 1) },
+		func() { yarex.Capture("a-b", yarex.Lit("a")) },
+		func() { yarex.Class('a') },
+		func() { yarex.Class('z', 'a') },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("should panic, but did not")
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+func TestBuilderGoGenerator(t *testing.T) {
+	ptn := yarex.Seq(yarex.Capture("year", yarex.Repeat(yarex.Class('0', '9'), 4, 4)), yarex.Lit("-"), yarex.Capture("month", yarex.Repeat(yarex.Class('0', '9'), 2, 2))).String()
+	gg := yarex.NewGoGenerator("builder_test.go", "yarex_test")
+	if err := gg.Add(ptn); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := gg.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(fmt.Sprintf("%q", ptn))) {
+		t.Errorf("generated code should register %q, but did not:\n%s", ptn, buf.String())
+	}
+}
+
+func TestSubexpNames(t *testing.T) {
+	re := yarex.MustCompile(`(?P<year>\d+)-(\d+)-(?<day>\d+)`)
+	if names, want := re.SubexpNames(), []string{"", "year", "", "day"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("want %q, but got %q", want, names)
+	}
+	for _, ptn := range []string{`(?P<>a)`, `(?P<a-b>a)`, `(?P<a>a)(?P<a>b)`, `(?P<a`} {
+		if _, err := yarex.Compile(ptn); err == nil {
+			t.Errorf("Compile(%q) should fail, but did not", ptn)
+		}
+	}
+}
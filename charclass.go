@@ -2,9 +2,11 @@ package yarex
 
 import (
 	"fmt"
-	"regexp"
+	"math/bits"
+	"sort"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 type CharClass interface {
@@ -27,9 +29,8 @@ func (c AsciiMaskClass) Contains(r rune) bool {
 	return (c.Hi & (1 << (r - 64))) != 0
 }
 
-func (_ AsciiMaskClass) String() string {
-	// tentative implementation
-	return ":AsciiMask:"
+func (c AsciiMaskClass) String() string {
+	return charClassString(c)
 }
 
 type CompAsciiMaskClass struct {
@@ -46,9 +47,8 @@ func (c CompAsciiMaskClass) Contains(r rune) bool {
 	return (c.Hi & (1 << (r - 64))) == 0
 }
 
-func (_ CompAsciiMaskClass) String() string {
-	// tentative implementation
-	return ":NegAsciiMask:"
+func (c CompAsciiMaskClass) String() string {
+	return charClassString(c)
 }
 
 // toAsciiMaskClass returns input as-is if impossible to convert to asciiMaskClass
@@ -59,6 +59,9 @@ func toAsciiMaskClass(c CharClass) CharClass {
 		if len(rt.R32) != 0 {
 			return c
 		}
+		if len(rt.R16) == 0 {
+			return AsciiMaskClass{}
+		}
 		if rt.R16[len(rt.R16)-1].Hi > 127 {
 			return c
 		}
@@ -98,26 +101,7 @@ func (c *RangeTableClass) HasOnlySingleChar() (rune, bool) {
 }
 
 func (rt *RangeTableClass) String() string {
-	var buf strings.Builder
-	for _, r := range rt.R16 {
-		if r.Lo == r.Hi {
-			buf.WriteString(regexp.QuoteMeta(string([]rune{rune(r.Lo)})))
-		} else {
-			buf.WriteString(regexp.QuoteMeta(string([]rune{rune(r.Lo)})))
-			buf.WriteString("-")
-			buf.WriteString(regexp.QuoteMeta(string([]rune{rune(r.Hi)})))
-		}
-	}
-	for _, r := range rt.R32 {
-		if r.Lo == r.Hi {
-			buf.WriteString(regexp.QuoteMeta(string([]rune{rune(r.Lo)})))
-		} else {
-			buf.WriteString(regexp.QuoteMeta(string([]rune{rune(r.Lo)})))
-			buf.WriteString("-")
-			buf.WriteString(regexp.QuoteMeta(string([]rune{rune(r.Hi)})))
-		}
-	}
-	return buf.String()
+	return charClassString(rt)
 }
 
 type CompClass struct{ CharClass }
@@ -127,7 +111,7 @@ func (c CompClass) Contains(r rune) bool {
 }
 
 func (nc CompClass) String() string {
-	return "^" + nc.CharClass.String()
+	return charClassString(nc)
 }
 
 type CompositeClass []CharClass
@@ -142,11 +126,7 @@ func (cc CompositeClass) Contains(r rune) bool {
 }
 
 func (cc CompositeClass) String() string {
-	var buf strings.Builder
-	for _, c := range ([]CharClass)(cc) {
-		buf.WriteString(c.String())
-	}
-	return buf.String()
+	return charClassString(cc)
 }
 
 func NegateCharClass(c CharClass) CharClass {
@@ -158,6 +138,10 @@ func NegateCharClass(c CharClass) CharClass {
 		}
 	case AsciiMaskClass:
 		return CompAsciiMaskClass{x}
+	case CompAsciiMaskClass:
+		return x.AsciiMaskClass
+	case CompClass:
+		return x.CharClass
 	}
 	return CompClass{c}
 }
@@ -185,7 +169,7 @@ func negateRangeTable(in *unicode.RangeTable) *unicode.RangeTable {
 			out.LatinOffset++
 		}
 	}
-	start = 0
+	start = 0x10000
 	for _, r := range in.R32 {
 		if r.Stride != 1 {
 			return nil
@@ -314,7 +298,7 @@ func mergeRangeTable(left, right *unicode.RangeTable) *unicode.RangeTable {
 			out.R32 = append(out.R32, next)
 			continue
 		}
-		if next.Stride != 1 || out.R16[len(out.R16)-1].Stride != 1 { // If either Stride is not 1, give up to merge.
+		if next.Stride != 1 || out.R32[len(out.R32)-1].Stride != 1 { // If either Stride is not 1, give up to merge.
 			return nil
 		}
 		if next.Lo <= out.R32[len(out.R32)-1].Hi+1 { // If the next range is overlapping or adjoininig the previus one, merge them.
@@ -348,3 +332,265 @@ func rangeTableFromTo(lo, hi rune) *unicode.RangeTable {
 	}
 	return out
 }
+
+// IntersectCharClass returns a CharClass containing characters in both a and b.
+func IntersectCharClass(a, b CharClass) CharClass {
+	ra, okA := classRanges(a)
+	rb, okB := classRanges(b)
+	if okA && okB {
+		return classOfRanges(intersectRanges(ra, rb))
+	}
+	// a && b == ^(^a || ^b)
+	return NegateCharClass(MergeCharClass(NegateCharClass(a), NegateCharClass(b)))
+}
+
+// SubtractCharClass returns a CharClass containing characters in a but not in b.
+func SubtractCharClass(a, b CharClass) CharClass {
+	ra, okA := classRanges(a)
+	rb, okB := classRanges(b)
+	if okA && okB {
+		return classOfRanges(intersectRanges(ra, complementRanges(rb)))
+	}
+	return IntersectCharClass(a, NegateCharClass(b))
+}
+
+// normalizeCharClass converts c into AsciiMaskClass, CompAsciiMaskClass or
+// *RangeTableClass in this order of preference, if possible.
+func normalizeCharClass(c CharClass) CharClass {
+	if rs, ok := classRanges(c); ok {
+		return classOfRanges(rs)
+	}
+	return c
+}
+
+// singleCharOf returns the character if c contains only a single one.
+func singleCharOf(c CharClass) (rune, bool) {
+	switch x := c.(type) {
+	case AsciiMaskClass:
+		if bits.OnesCount64(x.Hi)+bits.OnesCount64(x.Lo) == 1 {
+			if x.Lo != 0 {
+				return rune(bits.TrailingZeros64(x.Lo)), true
+			}
+			return rune(64 + bits.TrailingZeros64(x.Hi)), true
+		}
+	case *RangeTableClass:
+		return x.HasOnlySingleChar()
+	}
+	return 0, false
+}
+
+// runeRange is a range of characters from lo to hi inclusively.
+type runeRange struct {
+	lo, hi rune
+}
+
+// charClassString returns the canonical representation of c as a bracket
+// expression, which is parsed back into an equivalent class. Two classes
+// containing the same characters have the same representation.
+// A class containing unicode.MaxRune is represented by its complement, e.g. [^a-z].
+func charClassString(c CharClass) string {
+	rs, ok := classRanges(c)
+	if !ok {
+		rs = scanRanges(c)
+	}
+	if len(rs) == 0 {
+		return `[^\x{0}-\x{10ffff}]`
+	}
+	var buf strings.Builder
+	buf.WriteByte('[')
+	if rs[len(rs)-1].hi == unicode.MaxRune && (len(rs) > 1 || rs[0].lo != 0) {
+		buf.WriteByte('^')
+		rs = complementRanges(rs)
+	}
+	for _, r := range rs {
+		buf.WriteString(quoteRune(r.lo, true))
+		if r.lo == r.hi {
+			continue
+		}
+		if r.lo+1 != r.hi {
+			buf.WriteByte('-')
+		}
+		buf.WriteString(quoteRune(r.hi, true))
+	}
+	buf.WriteByte(']')
+	return buf.String()
+}
+
+// scanRanges returns ranges of characters in c by testing every character.
+// This is slow, and used only for classes unknown to classRanges.
+func scanRanges(c CharClass) []runeRange {
+	var rs []runeRange
+	for r := rune(0); r <= unicode.MaxRune; r++ {
+		if !c.Contains(r) {
+			continue
+		}
+		if n := len(rs); n > 0 && rs[n-1].hi+1 == r {
+			rs[n-1].hi = r
+		} else {
+			rs = append(rs, runeRange{r, r})
+		}
+	}
+	return rs
+}
+
+// classRanges returns sorted and non-overlapping ranges of characters in c.
+// It fails for classes consisting of unknown implementations of CharClass.
+func classRanges(c CharClass) ([]runeRange, bool) {
+	switch x := c.(type) {
+	case AsciiMaskClass:
+		return asciiMaskRanges(x), true
+	case CompAsciiMaskClass:
+		return complementRanges(asciiMaskRanges(x.AsciiMaskClass)), true
+	case *RangeTableClass:
+		rs := make([]runeRange, 0, len(x.R16)+len(x.R32))
+		add := func(lo, hi, stride uint32) {
+			if hi > unicode.MaxRune {
+				hi = unicode.MaxRune
+			}
+			if stride == 1 {
+				if lo <= hi {
+					rs = append(rs, runeRange{rune(lo), rune(hi)})
+				}
+				return
+			}
+			for r := lo; r <= hi; r += stride {
+				rs = append(rs, runeRange{rune(r), rune(r)})
+			}
+		}
+		for _, r := range x.R16 {
+			add(uint32(r.Lo), uint32(r.Hi), uint32(r.Stride))
+		}
+		for _, r := range x.R32 {
+			add(r.Lo, r.Hi, r.Stride)
+		}
+		return unionRanges(rs), true
+	case CompositeClass:
+		var rs []runeRange
+		for _, c := range x {
+			r, ok := classRanges(c)
+			if !ok {
+				return nil, false
+			}
+			rs = append(rs, r...)
+		}
+		return unionRanges(rs), true
+	case CompClass:
+		rs, ok := classRanges(x.CharClass)
+		if !ok {
+			return nil, false
+		}
+		return complementRanges(rs), true
+	case FoldClass:
+		rs, ok := classRanges(x.CharClass)
+		if !ok {
+			return nil, false
+		}
+		return classRanges((*RangeTableClass)(foldRangeTable(rangeTableOfRanges(rs))))
+	}
+	return nil, false
+}
+
+func classOfRanges(rs []runeRange) CharClass {
+	if len(rs) == 0 || rs[len(rs)-1].hi < utf8.RuneSelf {
+		return asciiMaskOfRanges(rs)
+	}
+	if comp := complementRanges(rs); len(comp) == 0 || comp[len(comp)-1].hi < utf8.RuneSelf {
+		return CompAsciiMaskClass{asciiMaskOfRanges(comp)}
+	}
+	return (*RangeTableClass)(rangeTableOfRanges(rs))
+}
+
+func asciiMaskRanges(c AsciiMaskClass) []runeRange {
+	var rs []runeRange
+	for r := rune(0); r < utf8.RuneSelf; r++ {
+		if !c.Contains(r) {
+			continue
+		}
+		if n := len(rs); n > 0 && rs[n-1].hi+1 == r {
+			rs[n-1].hi = r
+		} else {
+			rs = append(rs, runeRange{r, r})
+		}
+	}
+	return rs
+}
+
+// asciiMaskOfRanges assumes that rs contains only ASCII characters.
+func asciiMaskOfRanges(rs []runeRange) AsciiMaskClass {
+	var mask AsciiMaskClass
+	for _, r := range rs {
+		for i := r.lo; i <= r.hi; i++ {
+			if i < 64 {
+				mask.Lo |= 1 << uint(i)
+			} else {
+				mask.Hi |= 1 << uint(i-64)
+			}
+		}
+	}
+	return mask
+}
+
+func rangeTableOfRanges(rs []runeRange) *unicode.RangeTable {
+	out := &unicode.RangeTable{R16: []unicode.Range16{}, R32: []unicode.Range32{}}
+	for _, r := range rs {
+		rt := rangeTableFromTo(r.lo, r.hi)
+		out.R16 = append(out.R16, rt.R16...)
+		out.R32 = append(out.R32, rt.R32...)
+		out.LatinOffset += rt.LatinOffset
+	}
+	return out
+}
+
+// unionRanges sorts rs and merges overlapping or adjoining ranges.
+func unionRanges(rs []runeRange) []runeRange {
+	sort.Slice(rs, func(i, j int) bool { return rs[i].lo < rs[j].lo })
+	out := []runeRange{}
+	for _, r := range rs {
+		if n := len(out); n > 0 && r.lo <= out[n-1].hi+1 {
+			if r.hi > out[n-1].hi {
+				out[n-1].hi = r.hi
+			}
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func intersectRanges(a, b []runeRange) []runeRange {
+	out := []runeRange{}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		lo, hi := a[i].lo, a[i].hi
+		if b[j].lo > lo {
+			lo = b[j].lo
+		}
+		if b[j].hi < hi {
+			hi = b[j].hi
+		}
+		if lo <= hi {
+			out = append(out, runeRange{lo, hi})
+		}
+		if a[i].hi < b[j].hi {
+			i++
+		} else {
+			j++
+		}
+	}
+	return out
+}
+
+// complementRanges returns ranges of characters not in rs up to unicode.MaxRune.
+func complementRanges(rs []runeRange) []runeRange {
+	out := []runeRange{}
+	next := rune(0)
+	for _, r := range rs {
+		if next < r.lo {
+			out = append(out, runeRange{next, r.lo - 1})
+		}
+		next = r.hi + 1
+	}
+	if next <= unicode.MaxRune {
+		out = append(out, runeRange{next, unicode.MaxRune})
+	}
+	return out
+}
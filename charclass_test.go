@@ -1,6 +1,8 @@
 package yarex
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 	"unicode"
 )
@@ -29,8 +31,8 @@ var classDigit = (*RangeTableClass)(&unicode.RangeTable{
 
 func TestRangeTableClass(t *testing.T) {
 	aB0 := (*RangeTableClass)(&unicode.RangeTable{[]unicode.Range16{{'0', '0', '\x01'}, {'B', 'B', '\x01'}, {'a', 'a', '\x01'}}, []unicode.Range32{}, 3})
-	if aB0.String() != "0Ba" {
-		t.Errorf("expect %q, but got %q", "0Ba", aB0)
+	if aB0.String() != "[0Ba]" {
+		t.Errorf("expect %q, but got %q", "[0Ba]", aB0)
 	}
 	for i := '\000'; i <= 0xFFFFF; i++ { // Test only up to 0xFFFFF due to long-running test
 		switch i {
@@ -76,8 +78,8 @@ func TestNegateCharClass_Lm(t *testing.T) {
 	if _, ok := notLm.(CompClass); !ok {
 		t.Errorf("notLm should be of type negClass, but actually of type %T", notLm)
 	}
-	if notLm.String()[0] != '^' {
-		t.Errorf("expect %q, but got %q", "^...", notLm.String())
+	if !strings.HasPrefix(notLm.String(), "[^ʰ-ˁ") {
+		t.Errorf("expect %q, but got %q", "[^ʰ-ˁ...]", notLm.String())
 	}
 	for i := '\000'; i <= 0xFFFFF; i++ { // Test only up to 0xFFFFF due to long-running test
 		if notLm.Contains(i) != !unicode.Is(unicode.Lm, i) {
@@ -104,8 +106,8 @@ func TestMergeCharClass_AlphaNum(t *testing.T) {
 	if _, ok := alphanum.(*RangeTableClass); !ok {
 		t.Errorf("alphanum should be of type *rangeTableClass, but actually of type %T", alphanum)
 	}
-	if alphanum.String() != "0-9A-Za-z" {
-		t.Errorf("expect %q, but got %q", "0-9A-Za-z", alphanum)
+	if alphanum.String() != "[0-9A-Za-z]" {
+		t.Errorf("expect %q, but got %q", "[0-9A-Za-z]", alphanum)
 	}
 	for i := '\000'; i <= 0xFFFFF; i++ { // Test only up to 0xFFFFF due to long-running test
 		if alphanum.Contains(i) != ('A' <= i && i <= 'Z' || 'a' <= i && i <= 'z' || '0' <= i && i <= '9') {
@@ -113,3 +115,86 @@ func TestMergeCharClass_AlphaNum(t *testing.T) {
 		}
 	}
 }
+
+func TestFoldCharClass(t *testing.T) {
+	tests := []CharClass{
+		classLowerAlpha,
+		classDigit,
+		AsciiMaskClass{Lo: 0, Hi: 1 << ('k' - 64)},
+		(*RangeTableClass)(rangeTableFromTo('α', 'ω')),
+		(*RangeTableClass)(unicode.Lu),
+		CompositeClass{classDigit, (*RangeTableClass)(unicode.Lm)},
+		NegateCharClass(classAlpha),
+		CompClass{(*RangeTableClass)(unicode.Ll)},
+	}
+	for _, c := range tests {
+		folded := FoldCharClass(c)
+		for i := '\000'; i <= 0x1FFFF; i++ {
+			want := c.Contains(i)
+			for f := unicode.SimpleFold(i); f != i && !want; f = unicode.SimpleFold(f) {
+				want = c.Contains(f)
+			}
+			if folded.Contains(i) != want {
+				t.Errorf("FoldCharClass(%v).Contains(0x%x) should be %t, but actually not", c, i, want)
+				break
+			}
+		}
+	}
+	if c := FoldCharClass(classLowerAlpha); c.String() != "[A-Za-zſK]" {
+		t.Errorf("expect %q, but got %q", "[A-Za-zſK]", c)
+	}
+	if c := FoldCharClass(AsciiMaskClass{Lo: 1 << '0'}); c != (AsciiMaskClass{Lo: 1 << '0'}) {
+		t.Errorf("expect AsciiMaskClass as-is, but got %v of type %T", c, c)
+	}
+}
+
+func TestIntersectCharClass(t *testing.T) {
+	vowels := AsciiMaskClass{Hi: 1<<('a'-64) | 1<<('e'-64) | 1<<('i'-64) | 1<<('o'-64) | 1<<('u'-64)}
+	tests := []struct {
+		a, b CharClass
+		typ  string
+	}{
+		{classLowerAlpha, NegateCharClass(vowels), "yarex.AsciiMaskClass"},
+		{classAlpha, classDigit, "yarex.AsciiMaskClass"},
+		{(*RangeTableClass)(rangeTableFromTo('a', 'ω')), (*RangeTableClass)(rangeTableFromTo('X', 'β')), "*yarex.RangeTableClass"},
+		{NegateCharClass(classDigit), NegateCharClass(vowels), "yarex.CompAsciiMaskClass"},
+		{(*RangeTableClass)(unicode.Ll), classAlpha, "yarex.AsciiMaskClass"},
+	}
+	for _, test := range tests {
+		c := IntersectCharClass(test.a, test.b)
+		if typ := fmt.Sprintf("%T", c); typ != test.typ {
+			t.Errorf("IntersectCharClass(%v, %v) should be of type %s, but actually of type %s", test.a, test.b, test.typ, typ)
+		}
+		for i := '\000'; i <= 0x1FFFF; i++ {
+			if want := test.a.Contains(i) && test.b.Contains(i); c.Contains(i) != want {
+				t.Errorf("IntersectCharClass(%v, %v).Contains(0x%x) should be %t, but actually not", test.a, test.b, i, want)
+				break
+			}
+		}
+	}
+}
+
+func TestSubtractCharClass(t *testing.T) {
+	tests := []struct {
+		a, b CharClass
+		typ  string
+	}{
+		{classAlpha, classLowerAlpha, "yarex.AsciiMaskClass"},
+		{NegateCharClass(classDigit), classAlpha, "yarex.CompAsciiMaskClass"},
+		{(*RangeTableClass)(rangeTableFromTo('0', 'ω')), classAlpha, "*yarex.RangeTableClass"},
+		{(*RangeTableClass)(rangeTableFromTo('A', 'z')), (*RangeTableClass)(unicode.Lu), "yarex.AsciiMaskClass"},
+		{classDigit, classDigit, "yarex.AsciiMaskClass"},
+	}
+	for _, test := range tests {
+		c := SubtractCharClass(test.a, test.b)
+		if typ := fmt.Sprintf("%T", c); typ != test.typ {
+			t.Errorf("SubtractCharClass(%v, %v) should be of type %s, but actually of type %s", test.a, test.b, test.typ, typ)
+		}
+		for i := '\000'; i <= 0x1FFFF; i++ {
+			if want := test.a.Contains(i) && !test.b.Contains(i); c.Contains(i) != want {
+				t.Errorf("SubtractCharClass(%v, %v).Contains(0x%x) should be %t, but actually not", test.a, test.b, i, want)
+				break
+			}
+		}
+	}
+}
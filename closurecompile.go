@@ -0,0 +1,517 @@
+package yarex
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// closure is a matcher for a node in AST, which is bound with its follower
+// at compile time. It returns true if the rest of the pattern matches str at p.
+type closure = func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool
+
+func closureCompile(re Ast, latin1 bool) closure {
+	return (&closureCompiler{latin1: latin1}).compile(re, closureSuccess, 0)
+}
+
+// closureCompileSet compiles the members of a Set into a closure, which tries
+// all of them as setExecer does. They are compiled with a single compiler so
+// that repeat keys are unique throughout the set.
+func closureCompileSet(res []Ast) closure {
+	cc := &closureCompiler{}
+	members := make([]closure, len(res))
+	for i, re := range res {
+		key := ContextKey{'s', uint(i)}
+		body := cc.compile(re, func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+			onSuccess(ctx.Push(key, p))
+			return true
+		}, 0)
+		headOnly := canOnlyMatchAtBegining(re)
+		minReq := minRequiredLengthOfAst(re)
+		members[i] = func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+			if headOnly && p != 0 || len(str)-p < minReq || ctx.FindVal(key) >= 0 {
+				return false
+			}
+			return body(str, ctx, p, onSuccess)
+		}
+	}
+	return func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+		ok := false
+		for _, m := range members { // Unlike alternation, try all of them even after success.
+			if m(str, ctx, p, onSuccess) {
+				ok = true
+			}
+		}
+		return ok
+	}
+}
+
+func closureSuccess(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+	return ctx.Success(p, onSuccess)
+}
+
+type closureCompiler struct {
+	repeatCount uint
+	latin1      bool // each byte is a character, rather than UTF-8
+}
+
+// compile returns a closure matching re followed by k. kReq is the minimum
+// number of bytes required by k, and is used to fail fast.
+func (cc *closureCompiler) compile(re Ast, k closure, kReq int) closure {
+	switch r := re.(type) {
+	case AstLit:
+		lit := string(r)
+		if len(lit) == 0 {
+			return k
+		}
+		req := kReq + len(lit)
+		return func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+			if len(str)-p < req || str[p:p+len(lit)] != lit {
+				return false
+			}
+			return k(str, ctx, p+len(lit), onSuccess)
+		}
+	case AstFoldLit:
+		step := cc.closureStep(r)
+		req := kReq + minFoldLen(string(r))
+		return func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+			if len(str)-p < req {
+				return false
+			}
+			if p = step(str, p); p < 0 {
+				return false
+			}
+			return k(str, ctx, p, onSuccess)
+		}
+	case *AstSeq:
+		return cc.compileSeq(r.seq, k, kReq)
+	case *AstAlt:
+		return cc.compileAlt(r.opts, k, kReq)
+	case AstNotNewline:
+		req := kReq + 1
+		latin1 := cc.latin1
+		return func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+			if len(str)-p < req {
+				return false
+			}
+			r, size := rune(str[p]), 1
+			if r >= utf8.RuneSelf && !latin1 {
+				r, size = utf8.DecodeRuneInString(str[p:])
+			}
+			if r == '\n' {
+				return false
+			}
+			return k(str, ctx, p+size, onSuccess)
+		}
+	case AstCharClass:
+		req := kReq + 1
+		cls := r.CharClass
+		latin1 := cc.latin1
+		return func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+			if len(str)-p < req {
+				return false
+			}
+			r, size := rune(str[p]), 1
+			if r >= utf8.RuneSelf && !latin1 {
+				r, size = utf8.DecodeRuneInString(str[p:])
+			}
+			if !cls.Contains(r) {
+				return false
+			}
+			return k(str, ctx, p+size, onSuccess)
+		}
+	case *AstRepeat:
+		return cc.compileRepeat(r.re, r.min, r.max, k, kReq)
+	case *AstCap:
+		key := ContextKey{'c', r.index}
+		end := func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+			return k(str, ctx.Push(key, p), p, onSuccess)
+		}
+		body := cc.compile(r.re, end, kReq)
+		return func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+			return body(str, ctx.Push(key, p), p, onSuccess)
+		}
+	case AstBackRef:
+		key := ContextKey{'c', uint(r)}
+		return func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+			s, ok := ctx.GetCaptured(key)
+			if !ok || !strings.HasPrefix(str[p:], s) {
+				return false
+			}
+			return k(str, ctx, p+len(s), onSuccess)
+		}
+	case AstAssertBegin:
+		return func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+			if p != 0 {
+				return false
+			}
+			return k(str, ctx, p, onSuccess)
+		}
+	case AstAssertEnd:
+		return func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+			if p != len(str) {
+				return false
+			}
+			return k(str, ctx, p, onSuccess)
+		}
+	}
+	panic("EXECUTION SHOULD NOT REACH HERE")
+}
+
+func (cc *closureCompiler) compileSeq(seq []Ast, k closure, kReq int) closure {
+	for i := len(seq); i > 0; {
+		// Consecutive steps, which never backtrack, are fused into a single closure
+		// to reduce the depth of calls.
+		j := i
+		for j > 0 && cc.closureStep(seq[j-1]) != nil {
+			j--
+		}
+		if i-j >= 2 {
+			k, kReq = cc.compileSteps(seq[j:i], k, kReq)
+			i = j
+			continue
+		}
+		i--
+		k = cc.compile(seq[i], k, kReq)
+		kReq += minRequiredLengthOfAst(seq[i])
+	}
+	return k
+}
+
+// step matches a part of string at p and returns the next position, or -1 if fails.
+type step = func(str string, p int) int
+
+// closureStep returns a step for re if re never backtracks, or otherwise nil.
+func (cc *closureCompiler) closureStep(re Ast) step {
+	latin1 := cc.latin1
+	switch r := re.(type) {
+	case AstLit:
+		lit := string(r)
+		return func(str string, p int) int {
+			if len(str)-p < len(lit) || str[p:p+len(lit)] != lit {
+				return -1
+			}
+			return p + len(lit)
+		}
+	case AstFoldLit:
+		lit := string(r)
+		if !isASCIIFold(lit) {
+			return func(str string, p int) int {
+				return MatchFoldLit(str, p, lit)
+			}
+		}
+		mask := foldMask(lit)
+		return func(str string, p int) int {
+			if len(str)-p < len(lit) {
+				return -1
+			}
+			for i := 0; i < len(lit); i++ {
+				if str[p+i]|mask[i] != lit[i]|mask[i] {
+					return -1
+				}
+			}
+			return p + len(lit)
+		}
+	case AstNotNewline:
+		return func(str string, p int) int {
+			if p >= len(str) {
+				return -1
+			}
+			r, size := rune(str[p]), 1
+			if r >= utf8.RuneSelf && !latin1 {
+				r, size = utf8.DecodeRuneInString(str[p:])
+			}
+			if r == '\n' {
+				return -1
+			}
+			return p + size
+		}
+	case AstCharClass:
+		cls := r.CharClass
+		return func(str string, p int) int {
+			if p >= len(str) {
+				return -1
+			}
+			r, size := rune(str[p]), 1
+			if r >= utf8.RuneSelf && !latin1 {
+				r, size = utf8.DecodeRuneInString(str[p:])
+			}
+			if !cls.Contains(r) {
+				return -1
+			}
+			return p + size
+		}
+	case AstAssertBegin:
+		return func(str string, p int) int {
+			if p != 0 {
+				return -1
+			}
+			return p
+		}
+	case AstAssertEnd:
+		return func(str string, p int) int {
+			if p != len(str) {
+				return -1
+			}
+			return p
+		}
+	}
+	return nil
+}
+
+func (cc *closureCompiler) compileSteps(seq []Ast, k closure, kReq int) (closure, int) {
+	steps := make([]step, len(seq))
+	req := kReq
+	for i, r := range seq {
+		steps[i] = cc.closureStep(r)
+		req += minRequiredLengthOfAst(r)
+	}
+	return func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+		if len(str)-p < req {
+			return false
+		}
+		for _, st := range steps {
+			p = st(str, p)
+			if p < 0 {
+				return false
+			}
+		}
+		return k(str, ctx, p, onSuccess)
+	}, req
+}
+
+func (cc *closureCompiler) compileAlt(opts []Ast, k closure, kReq int) closure {
+	fs := make([]closure, len(opts))
+	for i, o := range opts {
+		fs[i] = cc.compile(o, k, kReq)
+	}
+	if len(fs) == 2 { // Most common case
+		left, right := fs[0], fs[1]
+		return func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+			return left(str, ctx, p, onSuccess) || right(str, ctx, p, onSuccess)
+		}
+	}
+	return func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+		for _, f := range fs {
+			if f(str, ctx, p, onSuccess) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func (cc *closureCompiler) compileRepeat(re Ast, min, max int, k closure, kReq int) closure {
+	if max == 0 {
+		return k
+	}
+	if min == 0 {
+		switch r := re.(type) {
+		// Optimization for repeating fixed-length patterns
+		case AstLit:
+			return cc.compileRepeatLit(string(r), max, k, kReq)
+		case AstCharClass:
+			return cc.compileRepeatClass(r.CharClass, max, k, kReq)
+		}
+	}
+	if isLargeRepeat(min, max) {
+		return cc.compileCountedRepeat(re, min, max, k, kReq)
+	}
+	if min > 0 {
+		follower := cc.compileRepeat(re, min-1, max-1, k, kReq)
+		return cc.compile(re, follower, kReq+minRequiredLengthOfAst(re)*(min-1))
+	}
+	if max > 0 {
+		left := cc.compile(re, cc.compileRepeat(re, 0, max-1, k, kReq), kReq)
+		return func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+			return left(str, ctx, p, onSuccess) || k(str, ctx, p, onSuccess)
+		}
+	}
+	// If you are here max < 0, which means infinite repeat
+	var self closure
+	loop := func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+		return self(str, ctx, p, onSuccess)
+	}
+	body := cc.compile(re, loop, kReq)
+	if !canMatchZeroWidth(re) { // If re does not match zero-width string, we can optimize by skipping zero-width check
+		self = func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+			return body(str, ctx, p, onSuccess) || k(str, ctx, p, onSuccess)
+		}
+		return self
+	}
+	cc.repeatCount++
+	key := ContextKey{'r', cc.repeatCount}
+	self = func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+		if ctx.FindVal(key) == p { // This means zero-width matching occurs.
+			return k(str, ctx, p, onSuccess) // So, terminate repeating.
+		}
+		return body(str, ctx.Push(key, p), p, onSuccess) || k(str, ctx, p, onSuccess)
+	}
+	return self
+}
+
+// compileCountedRepeat compiles re{min,max} into a loop which counts the
+// repeats in ctx, instead of unrolling re.
+func (cc *closureCompiler) compileCountedRepeat(re Ast, min, max int, k closure, kReq int) closure {
+	cc.repeatCount++
+	countKey := ContextKey{'n', cc.repeatCount}
+	zeroKey := ContextKey{'r', cc.repeatCount}
+	zeroWidth := canMatchZeroWidth(re)
+	var self closure
+	loop := func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+		return self(str, ctx, p, onSuccess)
+	}
+	body := cc.compile(re, loop, kReq)
+	self = func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+		n := ctx.FindVal(countKey)
+		if n < min {
+			return body(str, ctx.Push(countKey, n+1), p, onSuccess)
+		}
+		if zeroWidth {
+			if ctx.FindVal(zeroKey) == p { // This means zero-width matching occurs.
+				return k(str, ctx, p, onSuccess) // So, terminate repeating.
+			}
+			if (max < 0 || n < max) && body(str, ctx.Push(countKey, n+1).Push(zeroKey, p), p, onSuccess) {
+				return true
+			}
+			return k(str, ctx, p, onSuccess)
+		}
+		return (max < 0 || n < max) && body(str, ctx.Push(countKey, n+1), p, onSuccess) || k(str, ctx, p, onSuccess)
+	}
+	if zeroWidth {
+		return func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+			return self(str, ctx.Push(countKey, 0).Push(zeroKey, -1), p, onSuccess)
+		}
+	}
+	return func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+		return self(str, ctx.Push(countKey, 0), p, onSuccess)
+	}
+}
+
+func (cc *closureCompiler) compileRepeatLit(lit string, max int, k closure, kReq int) closure {
+	return func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+		endPos := len(str) - kReq - len(lit)
+		n := 0
+		for (max < 0 || n < max) && p <= endPos && str[p:p+len(lit)] == lit {
+			n++
+			p += len(lit)
+		}
+		for ; n > 0; n-- { // try backtrack
+			if k(str, ctx, p, onSuccess) {
+				return true
+			}
+			p -= len(lit)
+		}
+		return k(str, ctx, p, onSuccess)
+	}
+}
+
+func (cc *closureCompiler) compileRepeatClass(cls CharClass, max int, k closure, kReq int) closure {
+	latin1 := cc.latin1
+	return func(str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+		var localStack [16]int
+		endPos := len(str) - kReq - 1
+		n := 0
+		for (max < 0 || n < max) && p <= endPos {
+			r, size := rune(str[p]), 1
+			if r >= utf8.RuneSelf && !latin1 {
+				r, size = utf8.DecodeRuneInString(str[p:])
+			}
+			if !cls.Contains(r) {
+				break
+			}
+			if n == len(localStack) {
+				return repeatClassOnHeap(cls, latin1, max, k, endPos, localStack[:], str, ctx, p, onSuccess)
+			}
+			localStack[n] = p
+			n++
+			p += size
+		}
+		for ; n > 0; n-- { // try backtrack
+			if k(str, ctx, p, onSuccess) {
+				return true
+			}
+			p = localStack[n-1]
+		}
+		return k(str, ctx, p, onSuccess)
+	}
+}
+
+// repeatClassOnHeap continues repeatition of a char class when the local stack overflows.
+func repeatClassOnHeap(cls CharClass, latin1 bool, max int, k closure, endPos int, local []int, str string, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
+	heapStack := IntStackPool.Get().(*[]int)
+	defer func() { IntStackPool.Put(heapStack) }()
+	stack := append((*heapStack)[:0], local...)
+	for (max < 0 || len(stack) < max) && p <= endPos {
+		r, size := rune(str[p]), 1
+		if r >= utf8.RuneSelf && !latin1 {
+			r, size = utf8.DecodeRuneInString(str[p:])
+		}
+		if !cls.Contains(r) {
+			break
+		}
+		stack = append(stack, p)
+		p += size
+	}
+	*heapStack = stack
+	for n := len(stack); n > 0; n-- { // try backtrack
+		if k(str, ctx, p, onSuccess) {
+			return true
+		}
+		p = stack[n-1]
+	}
+	return k(str, ctx, p, onSuccess)
+}
+
+type closureExecer struct {
+	fun      closure
+	headOnly bool
+	minReq   int
+	latin1   bool
+	longest  bool
+}
+
+func newClosureExecer(re Ast, latin1 bool) *closureExecer {
+	return &closureExecer{closureCompile(re, latin1), canOnlyMatchAtBegining(re), minRequiredLengthOfAst(re), latin1, false}
+}
+
+// next returns the position to try matching next to p.
+func (exe *closureExecer) next(str string, p int) int {
+	if exe.latin1 {
+		return p + 1
+	}
+	return nextStart(str, p)
+}
+
+func (exe *closureExecer) exec(str string, pos int, anchored bool, onSuccess func(MatchContext)) bool {
+	if exe.longest {
+		return execLongest(str, pos, exe.headOnly, anchored, exe.minReq, exe.next, func(ctx MatchContext, p int, k func(MatchContext)) bool {
+			return exe.fun(str, ctx, p, k)
+		}, onSuccess)
+	}
+	headOnly := exe.headOnly
+	minReq := exe.minReq
+	if headOnly && pos != 0 {
+		return false
+	}
+	if minReq > len(str)-pos {
+		return false
+	}
+	sp := opStackPool.Get().(*[]opStackFrame)
+	stack := *sp
+	defer func() { *sp = stack; opStackPool.Put(sp) }()
+	getter := func() []opStackFrame { return stack }
+	setter := func(s []opStackFrame) { stack = s }
+	ctx0 := makeOpMatchContext(&str, &getter, &setter)
+	if exe.fun(str, ctx0.Push(ContextKey{'c', 0}, pos), pos, onSuccess) {
+		return true
+	}
+	if headOnly || anchored {
+		return false
+	}
+	for i := exe.next(str, pos); minReq <= len(str)-i; i = exe.next(str, i) {
+		if exe.fun(str, ctx0.Push(ContextKey{'c', 0}, i), i, onSuccess) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,64 +1,528 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
 	"go/ast"
+	"go/build"
 	"go/constant"
+	"go/importer"
 	"go/parser"
 	"go/token"
+	"go/types"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/Maki-Daisuke/go-yarex"
 )
 
 var reDirective = regexp.MustCompile(`^yarexgen\s*$`)
+var reSetDirective = regexp.MustCompile(`^yarexgen\s+set\s*$`)
+
+// reNamedDirective matches the directive to generate a typed matcher, e.g.
+// "//yarexgen ReEmail", optionally followed by comma-separated names of the
+// groups, e.g. "//yarexgen ReEmail user,host". Names must be exported, so they
+// never conflict with "set".
+var reNamedDirective = regexp.MustCompile(`^yarexgen\s+([A-Z]\w*)(?:\s+(\w*(?:,\w*)*))?\s*$`)
+
+// groupNames splits the comma-separated names of groups in a directive.
+func groupNames(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// generatedHeader is the first line of generated files, by which stale ones are found.
+const generatedHeader = "// Code generated by yarexgen "
+
+const yarexPath = "github.com/Maki-Daisuke/go-yarex"
+
+// compileFuncs are the functions whose constant arguments are compiled in -auto
+// mode. The value is true for the functions compiling a Set.
+var compileFuncs = map[string]bool{
+	"Compile":          false,
+	"MustCompile":      false,
+	"CompilePOSIX":     false,
+	"MustCompilePOSIX": false,
+	"CompileSet":       true,
+	"MustCompileSet":   true,
+}
+
+// fset and sourceImporter are shared by all packages, so that imported packages
+// are type-checked only once.
+var fset = token.NewFileSet()
+var sourceImporter types.Importer
+
+var (
+	auto    = flag.Bool("auto", false, "find calls of yarex.Compile and its friends with constant patterns instead of //yarexgen directives")
+	output  = flag.String("o", "", "output file name; only for a single file (default: FILE_yarex.go for FILE.go)")
+	pkgname = flag.String("pkg", "", "package name of the generated file; only for a single file (default: the package of FILE.go)")
+	tags    = flag.String("tags", "", "comma-separated list of build tags to select files in directories")
+	verbose = flag.Bool("v", false, "report generated and removed files, and skipped calls in -auto mode")
+	check   = flag.Bool("check", false, "do not write files, but fail if any generated file is out of date")
+	maxSize = flag.Int("maxsize", yarex.DefaultMaxCodeSize, "maximum size in bytes of the code generated for each pattern or set; larger ones are compiled at runtime (0: no limit)")
+)
+
+// failed is set when any error is reported, to exit with non-zero status.
+var failed = false
+
+// errorf reports an error at pos in the form of file:line:col.
+func errorf(pos token.Pos, format string, args ...interface{}) {
+	log.Printf("%v: %s", fset.Position(pos), fmt.Sprintf(format, args...))
+	failed = true
+}
+
+// warnf reports a warning at pos in the form of file:line:col, which does not
+// make yarexgen fail.
+func warnf(pos token.Pos, format string, args ...interface{}) {
+	log.Printf("%v: warning: %s", fset.Position(pos), fmt.Sprintf(format, args...))
+}
+
+// reportAddError reports err returned by adding patterns to GoGenerator at pos.
+// CodeSizeError is reported as a warning, since the patterns still work by
+// compiling them at runtime.
+func reportAddError(pos token.Pos, err error) {
+	var sizeErr *yarex.CodeSizeError
+	if errors.As(err, &sizeErr) {
+		warnf(pos, "%v", err)
+		return
+	}
+	errorf(pos, "%v", err)
+}
+
+func verbosef(format string, args ...interface{}) {
+	if *verbose {
+		log.Printf(format, args...)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage of yarexgen:
+	yarexgen [flags] file.go   generates file_yarex.go from file.go
+	yarexgen [flags] dir       generates one file per package in dir
+	yarexgen [flags] dir/...   generates for dir and all its subdirectories
+Flags:
+`)
+	flag.PrintDefaults()
+}
 
 func main() {
-	if len(os.Args) < 2 {
-		log.Println("Specify a file name.")
+	log.SetFlags(0)
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if (*output != "" || *pkgname != "") && (flag.NArg() != 1 || !strings.HasSuffix(flag.Arg(0), ".go")) {
+		log.Println("yarexgen: -o and -pkg can be used only for a single file")
+		os.Exit(2)
+	}
+	if *tags != "" {
+		// The source importer in -auto mode also uses build.Default.
+		build.Default.BuildTags = strings.Split(*tags, ",")
+	}
+	for _, arg := range flag.Args() {
+		if err := generateArg(arg); err != nil {
+			log.Println(err)
+			failed = true
+		}
+	}
+	if failed {
 		os.Exit(1)
 	}
-	filename := os.Args[1]
+}
 
-	fset := token.NewFileSet()
+func generateArg(arg string) error {
+	if arg == "..." || strings.HasSuffix(arg, "/...") {
+		root := strings.TrimSuffix(strings.TrimSuffix(arg, "..."), "/")
+		if root == "" {
+			root = "."
+		}
+		// Collect directories first, since generateDir may remove files in them.
+		var dirs []string
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.IsDir() {
+				return err
+			}
+			// Skip directories ignored by the go command.
+			if name := info.Name(); path != root && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata" || name == "vendor") {
+				return filepath.SkipDir
+			}
+			dirs = append(dirs, path)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, dir := range dirs {
+			if err := generateDir(dir); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	info, err := os.Stat(arg)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return generateDir(arg)
+	}
+	return generateFile(arg)
+}
+
+// generateFile generates the matchers for a single file into the file named
+// after it, e.g. foo_yarex_test.go for foo_test.go, or the file given by -o.
+func generateFile(filename string) error {
 	file, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
 	if err != nil {
-		log.Println("Error: ", err)
-		os.Exit(1)
+		return err
+	}
+	pkg := file.Name.Name
+	if *pkgname != "" {
+		pkg = *pkgname
+	}
+	out := *output
+	if out == "" {
+		re := regexp.MustCompile(`(?i:(_test)?\.go$)`)
+		out = re.ReplaceAllString(filename, "_yarex$0")
+	}
+	// The file name is used for the identifiers, which must not depend on the
+	// working directory.
+	generator := yarex.NewGoGenerator(filepath.Base(filename), pkg)
+	generator.SetMaxCodeSize(*maxSize)
+	if *auto {
+		// Other files of the package are not loaded, but patterns are folded
+		// as far as the constants are declared in file.
+		addCalls(generator, importPath(filepath.Dir(filename)), []*ast.File{file}, 1)
+	} else {
+		addDirectives(generator, file)
+	}
+	return writeGenerated(out, generator)
+}
+
+// generateDir generates the matchers for each package in dir into a single
+// file, and removes the generated files no longer needed. The files are named
+// after the packages, e.g. foo_yarex.go for package foo, foo_yarex_test.go for
+// the test files in package foo and foo_test_yarex_test.go for package foo_test.
+func generateDir(dir string) error {
+	pkg, err := build.ImportDir(dir, 0)
+	if err != nil {
+		if _, ok := err.(*build.NoGoError); ok {
+			return nil
+		}
+		return err
+	}
+	path := ""
+	if *auto {
+		path = importPath(dir)
+	}
+	written := map[string]bool{}
+	for _, group := range []struct {
+		pkgname string
+		path    string
+		deps    []string // files type-checked together, but not searched for patterns
+		files   []string
+		suffix  string
+	}{
+		{pkg.Name, path, nil, pkg.GoFiles, "_yarex.go"},
+		{pkg.Name, path, pkg.GoFiles, pkg.TestGoFiles, "_yarex_test.go"},
+		{pkg.Name + "_test", path + "_test", nil, pkg.XTestGoFiles, "_yarex_test.go"},
+	} {
+		out := filepath.Join(dir, group.pkgname+group.suffix)
+		generator := yarex.NewGoGenerator(filepath.Base(out), group.pkgname)
+		generator.SetMaxCodeSize(*maxSize)
+		var files []*ast.File
+		ntarget := 0
+		for i, name := range append(append([]string{}, group.deps...), group.files...) {
+			filename := filepath.Join(dir, name)
+			if generated, err := isGenerated(filename); err != nil {
+				return err
+			} else if generated {
+				continue
+			}
+			file, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+			if err != nil {
+				return err
+			}
+			files = append(files, file)
+			if i >= len(group.deps) {
+				ntarget++
+			}
+		}
+		found := false
+		if *auto {
+			found = ntarget > 0 && addCalls(generator, group.path, files, ntarget)
+		} else {
+			for _, file := range files[len(files)-ntarget:] {
+				if addDirectives(generator, file) {
+					found = true
+				}
+			}
+		}
+		if !found {
+			continue
+		}
+		if err := writeGenerated(out, generator); err != nil {
+			return err
+		}
+		written[out] = true
 	}
+	return removeStale(dir, written)
+}
 
-	generator := yarex.NewGoGenerator(filename, file.Name.Name)
+// addDirectives adds the patterns marked by directives in file to generator,
+// and reports whether any directive is found.
+func addDirectives(generator *yarex.GoGenerator, file *ast.File) bool {
+	found := false
+	cmap := ast.NewCommentMap(fset, file, file.Comments)
+	// Visit nodes in the order of position, so that the output is reproducible.
+	nodes := make([]ast.Node, 0, len(cmap))
+	for n := range cmap {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Pos() != nodes[j].Pos() {
+			return nodes[i].Pos() < nodes[j].Pos()
+		}
+		return nodes[i].End() < nodes[j].End()
+	})
 LOOP:
-	for n, cg := range ast.NewCommentMap(fset, file, file.Comments) {
-		for _, c := range cg {
-			if reDirective.MatchString(c.Text()) {
-				strs := findRegex(n)
-				if strs != nil {
-					generator.Add(strs...)
-				} else {
-					log.Printf("couldn't find regexp string in %s at line %d\n", filename, fset.File(c.Pos()).Line(c.Pos()))
+	for _, n := range nodes {
+		for _, c := range cmap[n] {
+			isSet := reSetDirective.MatchString(c.Text())
+			named := reNamedDirective.FindStringSubmatch(c.Text())
+			if !isSet && named == nil && !reDirective.MatchString(c.Text()) {
+				continue
+			}
+			found = true
+			lits := findRegex(n)
+			if lits == nil {
+				errorf(c.Pos(), "couldn't find regexp string for the directive")
+				continue LOOP
+			}
+			if isSet {
+				ptns := make([]string, len(lits))
+				for i, lit := range lits {
+					ptns[i] = stringOf(lit)
+				}
+				if err := generator.AddSet(ptns...); err != nil {
+					reportAddError(lits[0].Pos(), err)
+				}
+				continue LOOP
+			}
+			if named != nil {
+				if len(lits) != 1 {
+					errorf(c.Pos(), "typed matcher %s needs exactly one regexp string, but found %d", named[1], len(lits))
+				} else if err := generator.AddNamed(named[1], stringOf(lits[0]), groupNames(named[2])...); err != nil {
+					reportAddError(lits[0].Pos(), err)
 				}
 				continue LOOP
 			}
+			for _, lit := range lits {
+				if err := generator.Add(stringOf(lit)); err != nil {
+					reportAddError(lit.Pos(), err)
+				}
+			}
+			continue LOOP
 		}
 	}
+	return found
+}
 
-	re := regexp.MustCompile(`(?i:(_test)?\.go$)`)
-	out := re.ReplaceAllString(filename, "_yarex$0")
-	outfile, err := os.Create(out)
+// addCalls type-checks files as the package of path, and adds the patterns
+// given to compileFuncs in the last ntarget files to generator. Only the calls
+// with constant arguments are taken. It reports whether any pattern is found.
+func addCalls(generator *yarex.GoGenerator, path string, files []*ast.File, ntarget int) bool {
+	info := &types.Info{
+		Types: map[ast.Expr]types.TypeAndValue{},
+		Uses:  map[*ast.Ident]types.Object{},
+	}
+	if sourceImporter == nil {
+		sourceImporter = importer.ForCompiler(fset, "source", nil)
+	}
+	conf := types.Config{
+		Importer: sourceImporter,
+		Error:    func(error) {}, // Constants can be folded even if the package has errors.
+	}
+	conf.Check(path, fset, files, info)
+	found := false
+	for _, file := range files[len(files)-ntarget:] {
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			isSet, ok := compileFunc(info, call.Fun)
+			if !ok {
+				return true
+			}
+			if call.Ellipsis.IsValid() {
+				verbosef("%v: skipped call with variadic arguments", fset.Position(call.Pos()))
+				return true
+			}
+			ptns := make([]string, len(call.Args))
+			for i, arg := range call.Args {
+				v := info.Types[arg].Value
+				if v == nil || v.Kind() != constant.String {
+					verbosef("%v: skipped call with non-constant pattern", fset.Position(arg.Pos()))
+					return true
+				}
+				ptns[i] = constant.StringVal(v)
+			}
+			if isSet {
+				if err := generator.AddSet(ptns...); err != nil {
+					reportAddError(call.Lparen, err)
+					return true
+				}
+			} else {
+				for i, p := range ptns {
+					if err := generator.Add(p); err != nil {
+						reportAddError(call.Args[i].Pos(), err)
+						return true
+					}
+				}
+			}
+			found = true
+			return true
+		})
+	}
+	return found
+}
+
+// compileFunc reports whether fun refers to one of compileFuncs, and whether it compiles a Set.
+func compileFunc(info *types.Info, fun ast.Expr) (isSet, ok bool) {
+	var id *ast.Ident
+	switch f := fun.(type) {
+	case *ast.Ident: // Called in package yarex itself, or dot-imported
+		id = f
+	case *ast.SelectorExpr:
+		id = f.Sel
+	default:
+		return false, false
+	}
+	obj, _ := info.Uses[id].(*types.Func)
+	if obj == nil || obj.Pkg() == nil || obj.Pkg().Path() != yarexPath {
+		return false, false
+	}
+	if sig := obj.Type().(*types.Signature); sig.Recv() != nil {
+		return false, false // Methods of the same names
+	}
+	isSet, ok = compileFuncs[obj.Name()]
+	return isSet, ok
+}
+
+// importPath returns the import path of the package in dir, which is needed to
+// type-check package yarex itself.
+func importPath(dir string) string {
+	cmd := exec.Command("go", "list", "-e", "-f", "{{.ImportPath}}", ".")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return filepath.Base(dir)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// writeGenerated writes the code of generator into out. In -check mode, it
+// compares the code with out instead, and reports an error if they differ.
+func writeGenerated(out string, generator *yarex.GoGenerator) error {
+	var buf bytes.Buffer
+	if _, err := generator.WriteTo(&buf); err != nil {
+		return err
+	}
+	if *check {
+		old, err := ioutil.ReadFile(out)
+		if os.IsNotExist(err) {
+			log.Printf("%s: missing generated file", out)
+			failed = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(withoutHeader(old), withoutHeader(buf.Bytes())) {
+			log.Printf("%s: generated file is out of date", out)
+			failed = true
+		}
+		return nil
+	}
+	verbosef("writing %s", out)
+	return ioutil.WriteFile(out, buf.Bytes(), 0644)
+}
+
+// withoutHeader returns b without the first line if it is the header of
+// generated files. The header contains the version of yarexgen, which must not
+// make -check fail after updating go-yarex without any change to the code.
+func withoutHeader(b []byte) []byte {
+	if !bytes.HasPrefix(b, []byte(generatedHeader)) {
+		return b
+	}
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		return b[i+1:]
+	}
+	return nil
+}
+
+// isGenerated reports whether filename was generated by yarexgen.
+func isGenerated(filename string) (bool, error) {
+	f, err := os.Open(filename)
 	if err != nil {
-		panic(err)
+		return false, err
 	}
-	defer outfile.Close()
-	_, err = generator.WriteTo(outfile)
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && line == "" {
+		return false, nil // Empty file
+	}
+	return strings.HasPrefix(line, generatedHeader), nil
+}
+
+// removeStale removes the files generated by yarexgen in dir except those in
+// keep. In -check mode, it reports an error for them instead.
+func removeStale(dir string, keep map[string]bool) error {
+	infos, err := ioutil.ReadDir(dir)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	for _, info := range infos {
+		filename := filepath.Join(dir, info.Name())
+		if info.IsDir() || !strings.HasSuffix(filename, ".go") || keep[filename] {
+			continue
+		}
+		generated, err := isGenerated(filename)
+		if err != nil {
+			return err
+		}
+		if !generated {
+			continue
+		}
+		if *check {
+			log.Printf("%s: stale generated file", filename)
+			failed = true
+			continue
+		}
+		verbosef("removing %s", filename)
+		if err := os.Remove(filename); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// find string litetal
-func findRegex(n ast.Node) (out []string) {
+// findRegex finds string literals in n.
+func findRegex(n ast.Node) (out []*ast.BasicLit) {
 	ast.Inspect(n, func(n ast.Node) bool {
 		lit, ok := n.(*ast.BasicLit)
 		if !ok {
@@ -67,9 +531,12 @@ func findRegex(n ast.Node) (out []string) {
 		if lit.Kind != token.STRING {
 			return true
 		}
-		v := constant.MakeFromLiteral(lit.Value, lit.Kind, 0)
-		out = append(out, constant.StringVal(v))
+		out = append(out, lit)
 		return true
 	})
 	return out
 }
+
+func stringOf(lit *ast.BasicLit) string {
+	return constant.StringVal(constant.MakeFromLiteral(lit.Value, lit.Kind, 0))
+}
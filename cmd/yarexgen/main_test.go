@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testSource = `package foo
+
+var reFoo = "fo+(bar|baz)" //yarexgen
+`
+
+// inDir calls f in the working directory dir, and restores the original one.
+func inDir(t *testing.T, dir string, f func()) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Chdir(wd); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	f()
+}
+
+// TestGenerateFromOtherDir tests that the generated code does not depend on
+// the working directory nor the spelling of the arguments.
+func TestGenerateFromOtherDir(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "yarexgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	sub := filepath.Join(tmp, "foo")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(sub, "foo.go"), []byte(testSource), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(sub, "foo_yarex.go")
+	for _, tests := range [][]struct{ wd, arg string }{
+		{{tmp, "foo"}, {tmp, "./foo/..."}, {sub, "."}, {sub, sub}},     // directories
+		{{tmp, "foo/foo.go"}, {sub, "foo.go"}, {sub, "../foo/foo.go"}}, // single files
+	} {
+		var first []byte
+		for _, test := range tests {
+			inDir(t, test.wd, func() {
+				if err := generateArg(test.arg); err != nil || failed {
+					t.Fatalf("generateArg(%q) in %s failed: %v", test.arg, test.wd, err)
+				}
+			})
+			b, err := ioutil.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if first == nil {
+				first = b
+			} else if !bytes.Equal(first, b) {
+				t.Errorf("generateArg(%q) in %s should generate the same code as %q in %s, but got:\n%s\nand:\n%s",
+					test.arg, test.wd, tests[0].arg, tests[0].wd, first, b)
+			}
+			os.Remove(out)
+		}
+	}
+}
+
+// TestCheck tests that -check reports changes to the generated code, but not
+// to the version of yarexgen in the header.
+func TestCheck(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "yarexgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	if err := ioutil.WriteFile(filepath.Join(tmp, "foo.go"), []byte(testSource), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := generateArg(tmp); err != nil || failed {
+		t.Fatalf("generateArg(%q) failed: %v", tmp, err)
+	}
+	out := filepath.Join(tmp, "foo_yarex.go")
+	generated, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.IndexByte(generated, '\n')
+	*check = true
+	defer func() {
+		*check = false
+		failed = false
+	}()
+	for _, test := range []struct {
+		header, body string
+		failed       bool
+	}{
+		{generatedHeader + "v0.0.0-00010101000000-000000000000. DO NOT EDIT.", string(generated[i:]), false},
+		{string(generated[:i]), string(generated[i:]) + "\n", true},
+	} {
+		if err := ioutil.WriteFile(out, []byte(test.header+test.body), 0644); err != nil {
+			t.Fatal(err)
+		}
+		failed = false
+		if err := generateArg(tmp); err != nil {
+			t.Fatalf("generateArg(%q) failed: %v", tmp, err)
+		}
+		if failed != test.failed {
+			t.Errorf("-check should fail: want %v, but got %v for:\n%s", test.failed, failed, test.header+test.body)
+		}
+	}
+}
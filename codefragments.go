@@ -17,9 +17,15 @@ func (cf *codeFragments) codeLength() int {
 	return len(cf.code) + cf.follower.codeLength()
 }
 
+// prepend returns a new fragment of s followed by cf. cf may be nil, e.g. at the
+// end of an expression.
 func (cf *codeFragments) prepend(s string) *codeFragments {
+	minReq := 0
+	if cf != nil {
+		minReq = cf.minReq
+	}
 	return &codeFragments{
-		minReq:   cf.minReq,
+		minReq:   minReq,
 		code:     s,
 		follower: cf,
 	}
@@ -13,18 +13,56 @@ var IntStackPool = sync.Pool{
 
 var compiledRegexps = map[string]*Regexp{}
 
+var compiledSets = map[string]*Set{}
+
 func RegisterCompiledRegexp(s string, h bool, m int, f func(int, MatchContext, int, func(MatchContext)) bool) bool {
-	compiledRegexps[s] = &Regexp{s, &compiledExecer{f, h, m}}
+	compiledRegexps[s] = NewCompiledRegexp(s, h, m, f)
+	return true
+}
+
+// NewCompiledRegexp returns Regexp of a generated matcher without registering
+// it. This is called by generated typed matchers.
+func NewCompiledRegexp(s string, h bool, m int, f func(int, MatchContext, int, func(MatchContext)) bool) *Regexp {
+	return &Regexp{s, &compiledExecer{f, h, m, false}, mustNumSubexp(s)}
+}
+
+// RegisterCompiledOnePass registers a generated one-pass matcher, which only
+// matches at the beginning of string.
+func RegisterCompiledOnePass(s string, m int, f func(string, func(MatchContext)) bool) bool {
+	compiledRegexps[s] = NewCompiledOnePass(s, m, f)
 	return true
 }
 
+// NewCompiledOnePass is NewCompiledRegexp for a generated one-pass matcher.
+func NewCompiledOnePass(s string, m int, f func(string, func(MatchContext)) bool) *Regexp {
+	return &Regexp{s, &compiledOnePassExecer{f, m}, mustNumSubexp(s)}
+}
+
+type compiledOnePassExecer struct {
+	fun    func(string, func(MatchContext)) bool
+	minReq int
+}
+
+func (exe *compiledOnePassExecer) exec(str string, pos int, anchored bool, onSuccess func(MatchContext)) bool {
+	if pos != 0 || exe.minReq > len(str) {
+		return false
+	}
+	return exe.fun(str, onSuccess)
+}
+
 type compiledExecer struct {
 	fun      func(int, MatchContext, int, func(MatchContext)) bool
 	headOnly bool
 	minReq   int
+	longest  bool
 }
 
-func (exe *compiledExecer) exec(str string, pos int, onSuccess func(MatchContext)) bool {
+func (exe *compiledExecer) exec(str string, pos int, anchored bool, onSuccess func(MatchContext)) bool {
+	if exe.longest {
+		return execLongest(str, pos, exe.headOnly, anchored, exe.minReq, nextStart, func(ctx MatchContext, p int, k func(MatchContext)) bool {
+			return exe.fun(0, ctx, p, k)
+		}, onSuccess)
+	}
 	headOnly := exe.headOnly
 	minReq := exe.minReq
 	if headOnly && pos != 0 {
@@ -33,21 +71,37 @@ func (exe *compiledExecer) exec(str string, pos int, onSuccess func(MatchContext
 	if minReq > len(str)-pos {
 		return false
 	}
-	stack := *(opStackPool.Get().(*[]opStackFrame))
-	defer func() { opStackPool.Put(&stack) }()
+	sp := opStackPool.Get().(*[]opStackFrame)
+	stack := *sp
+	defer func() { *sp = stack; opStackPool.Put(sp) }()
 	getter := func() []opStackFrame { return stack }
 	setter := func(s []opStackFrame) { stack = s }
 	ctx0 := makeOpMatchContext(&str, &getter, &setter)
 	if exe.fun(0, ctx0.Push(ContextKey{'c', 0}, pos), pos, onSuccess) {
 		return true
 	}
-	if headOnly {
+	if headOnly || anchored {
 		return false
 	}
-	for i := pos + 1; minReq <= len(str)-i; i++ {
+	for i := nextStart(str, pos); minReq <= len(str)-i; i = nextStart(str, i) {
 		if exe.fun(0, ctx0.Push(ContextKey{'c', 0}, i), i, onSuccess) {
 			return true
 		}
 	}
 	return false
 }
+
+// RegisterCompiledSet registers a generated matcher for a Set. f is a single
+// function trying all the members from state 0, as setExecer does.
+func RegisterCompiledSet(ss []string, f func(int, MatchContext, int, func(MatchContext)) bool) bool {
+	compiledSets[setKey(ss)] = &Set{ss, compiledSetExecer{f}}
+	return true
+}
+
+type compiledSetExecer struct {
+	fun func(int, MatchContext, int, func(MatchContext)) bool
+}
+
+func (exe compiledSetExecer) exec(ctx MatchContext, pos int, onSuccess func(MatchContext)) bool {
+	return exe.fun(0, ctx, pos, onSuccess)
+}
@@ -0,0 +1,60 @@
+//go:build yarex_nounsafe
+// +build yarex_nounsafe
+
+package yarex
+
+// Contexts hold ordinary pointers when built with the tag yarex_nounsafe, so
+// that this package does not import unsafe. Since the pointers leak through
+// parameters of matchers, the string, the stack and the closures accessing it
+// are allocated on heap for each match. See context_unsafe.go.
+
+// usesUnsafe tells whether contexts are implemented with unsafe.
+const usesUnsafe = false
+
+type MatchContext struct {
+	str      *string                // string being matched
+	getStack *func() []opStackFrame // Accessors to stack to record capturing positions.
+	setStack *func([]opStackFrame)
+	stackTop int  // stack top
+	longest  bool // leftmost-longest mode, where Success does not stop matching
+}
+
+func makeOpMatchContext(str *string, getter *func() []opStackFrame, setter *func([]opStackFrame)) MatchContext {
+	return MatchContext{str, getter, setter, 0, false}
+}
+
+// Input returns the string being matched. This is called by compiled matchers.
+func (c MatchContext) Input() string {
+	return *c.str
+}
+
+func (c MatchContext) stack() []opStackFrame {
+	return (*c.getStack)()
+}
+
+func (c MatchContext) updateStack(st []opStackFrame) {
+	(*c.setStack)(st)
+}
+
+type matchContext struct {
+	str      *string              // string being matched
+	getStack *func() []stackFrame // Accessors to stack to record capturing positions.
+	setStack *func([]stackFrame)
+	stackTop int // stack top
+}
+
+func makeContext(str *string, getter *func() []stackFrame, setter *func([]stackFrame)) matchContext {
+	return matchContext{str, getter, setter, 0}
+}
+
+func (c matchContext) input() string {
+	return *c.str
+}
+
+func (c matchContext) stack() []stackFrame {
+	return (*c.getStack)()
+}
+
+func (c matchContext) updateStack(st []stackFrame) {
+	(*c.setStack)(st)
+}
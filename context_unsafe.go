@@ -0,0 +1,63 @@
+//go:build !yarex_nounsafe
+// +build !yarex_nounsafe
+
+package yarex
+
+import "unsafe"
+
+// Contexts hold pointers to the string and the accessors of the stack as
+// uintptr, so that parameters of matchers do not leak and the stack and the
+// closures accessing it can stay on the stack of the caller. Build with the tag
+// yarex_nounsafe not to import unsafe at all, at the cost of allocating them on
+// heap for each match. See context_safe.go.
+
+// usesUnsafe tells whether contexts are implemented with unsafe.
+const usesUnsafe = true
+
+type MatchContext struct {
+	Str      uintptr // *string                // string being matched
+	getStack uintptr // *func() []opStackFrame // Accessors to stack to record capturing positions.
+	setStack uintptr // *func([]opStackFrame)  // We use uintptr to avoid leaking param.
+	stackTop int     // stack top
+	longest  bool    // leftmost-longest mode, where Success does not stop matching
+}
+
+func makeOpMatchContext(str *string, getter *func() []opStackFrame, setter *func([]opStackFrame)) MatchContext {
+	return MatchContext{uintptr(unsafe.Pointer(str)), uintptr(unsafe.Pointer(getter)), uintptr(unsafe.Pointer(setter)), 0, false}
+}
+
+// Input returns the string being matched. This is called by compiled matchers.
+func (c MatchContext) Input() string {
+	return *(*string)(unsafe.Pointer(c.Str))
+}
+
+func (c MatchContext) stack() []opStackFrame {
+	return (*(*func() []opStackFrame)(unsafe.Pointer(c.getStack)))() // == c.getStack()
+}
+
+func (c MatchContext) updateStack(st []opStackFrame) {
+	(*(*func([]opStackFrame))(unsafe.Pointer(c.setStack)))(st) // == c.setStack(st)
+}
+
+type matchContext struct {
+	str      uintptr // *string              // string being matched
+	getStack uintptr // *func() []stackFrame // Accessors to stack to record capturing positions.
+	setStack uintptr // *func([]stackFrame)  // We use uintptr to avoid leaking param.
+	stackTop int     // stack top
+}
+
+func makeContext(str *string, getter *func() []stackFrame, setter *func([]stackFrame)) matchContext {
+	return matchContext{uintptr(unsafe.Pointer(str)), uintptr(unsafe.Pointer(getter)), uintptr(unsafe.Pointer(setter)), 0}
+}
+
+func (c matchContext) input() string {
+	return *(*string)(unsafe.Pointer(c.str))
+}
+
+func (c matchContext) stack() []stackFrame {
+	return (*(*func() []stackFrame)(unsafe.Pointer(c.getStack)))() // == c.getStack()
+}
+
+func (c matchContext) updateStack(st []stackFrame) {
+	(*(*func([]stackFrame))(unsafe.Pointer(c.setStack)))(st) // == c.setStack(st)
+}
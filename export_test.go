@@ -12,6 +12,8 @@ var (
 	AstMatch    = astMatch
 )
 
+const UsesUnsafe = usesUnsafe
+
 // MustCompileOp is identical to MustCompile, but ignores compiled version of regexp
 // and returns OpTree version.
 func MustCompileOp(ptn string) *Regexp {
@@ -21,7 +23,22 @@ func MustCompileOp(ptn string) *Regexp {
 	}
 	ast = optimizeAst(ast)
 	op := opCompile(ast)
-	return &Regexp{ptn, opExecer{op}}
+	return &Regexp{ptn, opExecer{op, false}, numSubexp(ast)}
+}
+
+// MustCompileClosure is identical to MustCompile, but ignores compiled version of regexp
+// and returns closure version.
+func MustCompileClosure(ptn string) *Regexp {
+	ast, err := parse(ptn)
+	if err != nil {
+		panic(err)
+	}
+	return &Regexp{ptn, newClosureExecer(optimizeAst(ast), false), numSubexp(ast)}
+}
+
+func IsClosureMatcher(r *Regexp) bool {
+	_, ok := r.exe.(*closureExecer)
+	return ok
 }
 
 func IsOpMatcher(r *Regexp) bool {
@@ -30,8 +47,19 @@ func IsOpMatcher(r *Regexp) bool {
 }
 
 func IsCompiledMatcher(r *Regexp) bool {
-	_, ok := r.exe.(*compiledExecer)
-	return ok
+	switch r.exe.(type) {
+	case *compiledExecer, *compiledOnePassExecer:
+		return true
+	}
+	return false
+}
+
+func IsOnePassMatcher(r *Regexp) bool {
+	switch r.exe.(type) {
+	case onePassExecer, *compiledOnePassExecer:
+		return true
+	}
+	return false
 }
 
 func DumpAst(re Ast) string {
@@ -101,3 +129,85 @@ func dumpAux(re Ast, n int, buf *strings.Builder) {
 	}
 	panic(fmt.Errorf("IMPLEMENT DUMP for %T", re))
 }
+
+// MustCompileSetClosure is identical to MustCompileSet, but ignores compiled
+// version of the set and returns closure version.
+func MustCompileSetClosure(ptns ...string) *Set {
+	asts := make([]Ast, len(ptns))
+	for i, p := range ptns {
+		ast, err := parse(p)
+		if err != nil {
+			panic(err)
+		}
+		asts[i] = optimizeAst(ast)
+	}
+	return &Set{ptns, closureSetExecer{closureCompileSet(asts)}}
+}
+
+func IsCompiledSet(s *Set) bool {
+	_, ok := s.exe.(compiledSetExecer)
+	return ok
+}
+
+// MustCompileOnePass is identical to MustCompile, but ignores compiled version of regexp
+// and returns one-pass version. It panics if the pattern is not one-pass.
+func MustCompileOnePass(ptn string) *Regexp {
+	ast, err := parse(ptn)
+	if err != nil {
+		panic(err)
+	}
+	ast = optimizeAst(ast)
+	if !isOnePass(ast) {
+		panic(fmt.Errorf("%q is not one-pass", ptn))
+	}
+	return &Regexp{ptn, onePassExecer{newOnePassProg(ast)}, numSubexp(ast)}
+}
+
+func IsOnePass(ptn string) bool {
+	ast, err := parse(ptn)
+	if err != nil {
+		panic(err)
+	}
+	return isOnePass(optimizeAst(ast))
+}
+
+// MustCompileBitParallel is identical to MustCompile, but ignores compiled version
+// of regexp and returns bit-parallel version. It panics if the pattern cannot be
+// run by a bit-parallel automaton.
+func MustCompileBitParallel(ptn string) *Regexp {
+	ast, err := parse(ptn)
+	if err != nil {
+		panic(err)
+	}
+	ast = optimizeAst(ast)
+	bp := newBitParallelExecer(ast, newClosureExecer(ast, false))
+	if bp == nil {
+		panic(fmt.Errorf("%q cannot be run by bit-parallel automaton", ptn))
+	}
+	return &Regexp{ptn, bp, numSubexp(ast)}
+}
+
+// FindStringSubmatchIndex returns the indices of the leftmost match and its
+// submatches as regexp does, for tests of captures.
+func FindStringSubmatchIndex(re *Regexp, s string) (loc []int) {
+	n := re.nsub + 1
+	re.exe.exec(s, 0, false, func(c MatchContext) {
+		loc = make([]int, 0, 2*n)
+		for i := 0; i < n; i++ {
+			if l := c.GetCapturedIndex(ContextKey{'c', uint(i)}); l != nil {
+				loc = append(loc, l...)
+			} else {
+				loc = append(loc, -1, -1)
+			}
+		}
+	})
+	return loc
+}
+
+// GenerateCharClass returns the Go expression constructing c in generated code.
+func GenerateCharClass(c CharClass) string {
+	gg := NewGoGenerator("export_test.go", "yarex_test")
+	var buf strings.Builder
+	gg.generateCharClassAux(c, nil).WriteTo(&buf)
+	return buf.String()
+}
@@ -0,0 +1,219 @@
+package yarex
+
+import (
+	"sort"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Range of characters which have other characters equivalent under simple
+// case folding. Characters out of this range fold only to themselves.
+const (
+	minFold = 0x0041
+	maxFold = 0x1e943
+)
+
+// FoldCharClass returns a CharClass closed under unicode.SimpleFold, i.e. it
+// contains all the characters equivalent to those in c ignoring case.
+// For example, [a-c] becomes [A-Ca-c], and [k] becomes [KkK].
+func FoldCharClass(c CharClass) CharClass {
+	if x, ok := c.(FoldClass); ok {
+		return x
+	}
+	if rs, ok := classRanges(c); ok {
+		return normalizeCharClass((*RangeTableClass)(foldRangeTable(rangeTableOfRanges(rs))))
+	}
+	if x, ok := c.(CompositeClass); ok {
+		cs := make([]CharClass, len(x))
+		for i, c := range x {
+			cs[i] = FoldCharClass(c)
+		}
+		return MergeCharClass(cs...)
+	}
+	return FoldClass{c}
+}
+
+// FoldClass contains a character if any of its case-folding equivalents is
+// contained by the underlying CharClass. It is used for classes which cannot
+// be folded in advance, e.g. negated ones.
+type FoldClass struct{ CharClass }
+
+func (c FoldClass) Contains(r rune) bool {
+	if c.CharClass.Contains(r) {
+		return true
+	}
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if c.CharClass.Contains(f) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c FoldClass) String() string {
+	return "(?i)" + c.CharClass.String()
+}
+
+// foldRangeTable returns a RangeTable closed under unicode.SimpleFold. It can
+// only handle RangeTables in which Stride = 1, and returns nil for the others.
+func foldRangeTable(rt *unicode.RangeTable) *unicode.RangeTable {
+	var extra []rune
+	foldables := foldableRunes()
+	add := func(lo, hi rune) {
+		i := sort.Search(len(foldables), func(i int) bool { return foldables[i] >= lo })
+		for ; i < len(foldables) && foldables[i] <= hi; i++ {
+			r := foldables[i]
+			for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+				if !unicode.Is(rt, f) {
+					extra = append(extra, f)
+				}
+			}
+		}
+	}
+	for _, r := range rt.R16 {
+		if r.Stride != 1 {
+			return nil
+		}
+		add(rune(r.Lo), rune(r.Hi))
+	}
+	for _, r := range rt.R32 {
+		if r.Stride != 1 {
+			return nil
+		}
+		add(rune(r.Lo), rune(r.Hi))
+	}
+	if len(extra) == 0 {
+		return rt
+	}
+	return mergeRangeTable(rt, rangeTableOfRunes(extra))
+}
+
+var (
+	foldablesOnce sync.Once
+	foldables     []rune
+)
+
+// foldableRunes returns the sorted list of characters which have other
+// characters equivalent under simple case folding.
+func foldableRunes() []rune {
+	foldablesOnce.Do(func() {
+		for r := rune(minFold); r <= maxFold; r++ {
+			if unicode.SimpleFold(r) != r {
+				foldables = append(foldables, r)
+			}
+		}
+	})
+	return foldables
+}
+
+// rangeTableOfRunes returns a RangeTable containing rs. rs may be unsorted and
+// have duplicates.
+func rangeTableOfRunes(rs []rune) *unicode.RangeTable {
+	ranges := make([]runeRange, len(rs))
+	for i, r := range rs {
+		ranges[i] = runeRange{r, r}
+	}
+	return rangeTableOfRanges(unionRanges(ranges))
+}
+
+// equalFold reports whether r and s are equivalent under simple case folding.
+func equalFold(r, s rune) bool {
+	if r == s {
+		return true
+	}
+	for f := unicode.SimpleFold(s); f != s; f = unicode.SimpleFold(f) {
+		if f == r {
+			return true
+		}
+	}
+	return false
+}
+
+// isASCIIFold reports whether all the characters in lit and their case-folding
+// equivalents are ASCII. Such a literal matches a string of the same length
+// and can be compared byte by byte. Note that 'k' and 's' are not the case,
+// since they are equivalent to KELVIN SIGN and LATIN SMALL LETTER LONG S.
+func isASCIIFold(lit string) bool {
+	for _, r := range lit {
+		if r >= utf8.RuneSelf {
+			return false
+		}
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if f >= utf8.RuneSelf {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// hasFold reports whether lit contains any character which has other
+// characters equivalent under simple case folding.
+func hasFold(lit string) bool {
+	for _, r := range lit {
+		if unicode.SimpleFold(r) != r {
+			return true
+		}
+	}
+	return false
+}
+
+// foldMask returns masks to compare an ASCII-folded literal byte by byte, that
+// is, str[i]|mask[i] == lit[i]|mask[i] if str[i] matches lit[i] ignoring case.
+func foldMask(lit string) []byte {
+	mask := make([]byte, len(lit))
+	for i := 0; i < len(lit); i++ {
+		if c := lit[i] | 0x20; 'a' <= c && c <= 'z' {
+			mask[i] = 0x20
+		}
+	}
+	return mask
+}
+
+// minFoldLen returns the minimum length in bytes of strings matching lit
+// ignoring case. Some characters are shorter than their equivalents, e.g.
+// KELVIN SIGN (3 bytes) is equivalent to 'k' (1 byte).
+func minFoldLen(lit string) int {
+	n := 0
+	for _, r := range lit {
+		min := utf8.RuneLen(r)
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if l := utf8.RuneLen(f); l < min {
+				min = l
+			}
+		}
+		n += min
+	}
+	return n
+}
+
+// MatchFoldLit matches lit against str at p ignoring case, and returns the
+// position after the match, or -1 if fails. This is called by compiled matchers.
+// Do not use this for any other purposes.
+func MatchFoldLit(str string, p int, lit string) int {
+	for i := 0; i < len(lit); {
+		if p >= len(str) {
+			return -1
+		}
+		c, l := str[p], lit[i]
+		if c < utf8.RuneSelf && l < utf8.RuneSelf { // Fast path for ASCII
+			if c != l {
+				if x := c | 0x20; x != l|0x20 || x < 'a' || 'z' < x {
+					return -1
+				}
+			}
+			p++
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(str[p:])
+		lr, lsize := utf8.DecodeRuneInString(lit[i:])
+		if !equalFold(r, lr) {
+			return -1
+		}
+		p += size
+		i += lsize
+	}
+	return p
+}
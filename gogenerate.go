@@ -1,28 +1,55 @@
 package yarex
 
 import (
+	"bytes"
 	"fmt"
+	"go/format"
+	"hash/fnv"
 	"io"
+	"reflect"
 	"regexp"
+	"runtime/debug"
+	"sort"
 	"strings"
 )
 
 var reNotWord = regexp.MustCompile(`\W`)
 
+const modulePath = "github.com/Maki-Daisuke/go-yarex"
+
+// charClassResult is a package variable used to match a char class.
 type charClassResult struct {
 	id   string
+	doc  string // what the variable is, e.g. "the char class of [a-z]"
 	code *codeFragments
 }
 
+// generatedFunc is a generated matcher function, which is shared by all the
+// patterns parsed into the same Ast.
+type generatedFunc struct {
+	id          string
+	ast         Ast             // Ast of backtracking matcher, or nil for one-pass one
+	code        *codeFragments  // the function without its doc comment
+	register    string          // format of the expression registering the pattern given as %[1]q
+	constructor string          // format of the expression constructing Regexp of the pattern given as %[1]q
+	ptns        map[string]bool // patterns sharing the function
+}
+
 type GoGenerator struct {
 	pkgname      string
 	useUtf8      bool
+	useUnicode   bool // whether any RangeTableClass is generated, which needs unicode
+	useBacktrack bool // whether any backtracking matcher is generated, which needs strconv
 	stateCount   uint
 	idPrefix     string
-	idCount      uint
+	ids          map[string]string // kinds and keys by identifiers
 	repeatCount  uint
-	funcs        map[string]*codeFragments
-	charClasses  map[string]charClassResult
+	funcs        map[string]*generatedFunc  // matcher functions by the canonical patterns of their Asts
+	funcOf       map[string]*generatedFunc  // matcher functions by the added patterns, or nil if they are too large
+	sets         map[string]*codeFragments  // nil if they are too large
+	charClasses  map[string]charClassResult // keyed by doc, which describes the content
+	named        map[string]typedMatcher    // typed matchers by their names
+	maxCodeSize  int
 	useCharClass bool
 	useSmallLoop bool
 }
@@ -31,14 +58,28 @@ func NewGoGenerator(file string, pkg string) *GoGenerator {
 	gg := &GoGenerator{}
 	gg.pkgname = pkg
 	gg.idPrefix = fmt.Sprintf("yarexGen_%s", reNotWord.ReplaceAllString(file, "_"))
-	gg.funcs = map[string]*codeFragments{}
+	gg.ids = map[string]string{}
+	gg.funcs = map[string]*generatedFunc{}
+	gg.funcOf = map[string]*generatedFunc{}
+	gg.sets = map[string]*codeFragments{}
 	gg.charClasses = map[string]charClassResult{}
+	gg.named = map[string]typedMatcher{}
+	gg.maxCodeSize = DefaultMaxCodeSize
 	return gg
 }
 
+// Add adds patterns to be compiled into matcher functions. Patterns parsed
+// into the same Ast, e.g. `a\d` and `a[0-9]`, share the same function. Large
+// tails common to patterns, e.g. `\d{3}-\d{4}` of `a+\d{3}-\d{4}` and
+// `b*\d{3}-\d{4}`, are generated as functions called by them.
+//
+// If the code generated for a pattern exceeds the limit set by SetMaxCodeSize,
+// the pattern is not compiled, and Add returns *CodeSizeError after adding the
+// other patterns. The error is not returned again for the same pattern.
 func (gg *GoGenerator) Add(rs ...string) error {
+	var sizeErr error
 	for _, r := range rs {
-		if _, ok := gg.funcs[r]; ok {
+		if _, ok := gg.funcOf[r]; ok {
 			continue
 		}
 		ast, err := parse(r)
@@ -46,60 +87,172 @@ func (gg *GoGenerator) Add(rs ...string) error {
 			return err
 		}
 		ast = optimizeAst(ast)
-		code := gg.generateFunc(r, ast)
-		gg.funcs[r] = code
+		key := ast.String()
+		f, ok := gg.funcs[key]
+		if !ok {
+			err := gg.limitCodeSize([]string{r}, func() *codeFragments {
+				if isOnePass(ast) {
+					f = gg.generateOnePassFunc(key, ast)
+				} else {
+					f = gg.generateFunc(key, ast)
+				}
+				return f.code
+			})
+			if err != nil {
+				if sizeErr == nil {
+					sizeErr = err
+				}
+				gg.funcOf[r] = nil
+				continue
+			}
+			gg.funcs[key] = f
+		}
+		f.ptns[r] = true
+		gg.funcOf[r] = f
 	}
+	return sizeErr
+}
+
+// AddSet adds patterns to be compiled into a single function as a Set. As Add,
+// it returns *CodeSizeError if the generated code exceeds the limit.
+func (gg *GoGenerator) AddSet(rs ...string) error {
+	key := setKey(rs)
+	if _, ok := gg.sets[key]; ok {
+		return nil
+	}
+	asts := make([]Ast, len(rs))
+	for i, r := range rs {
+		ast, err := parse(r)
+		if err != nil {
+			return err
+		}
+		asts[i] = optimizeAst(ast)
+	}
+	var code *codeFragments
+	err := gg.limitCodeSize(rs, func() *codeFragments {
+		code = gg.generateSetFunc(rs, asts)
+		return code
+	})
+	if err != nil {
+		gg.sets[key] = nil
+		return err
+	}
+	gg.sets[key] = code
 	return nil
 }
 
+// constructor returns the expression constructing Regexp of ptn, which calls
+// the generated function if any, or compiles ptn at runtime otherwise.
+func (gg *GoGenerator) constructor(ptn string) string {
+	if f := gg.funcOf[ptn]; f != nil {
+		return fmt.Sprintf(f.constructor, ptn)
+	}
+	return fmt.Sprintf("yarex.MustCompile(%q)", ptn)
+}
+
+// WriteTo writes the generated code, which is formatted as gofmt does. The
+// output only depends on the added patterns, so it is reproducible.
 func (gg *GoGenerator) WriteTo(w io.Writer) (int64, error) {
-	var acc int64
-	importUtf8 := ""
+	var buf bytes.Buffer
+	tails, codes := gg.shareTails()
+	imports := ""
+	if gg.useBacktrack {
+		imports += "\"strconv\"\n"
+	}
+	if gg.useUnicode {
+		imports += "\"unicode\"\n"
+	}
 	if gg.useUtf8 {
-		importUtf8 = `"unicode/utf8"`
+		imports += "\"unicode/utf8\"\n"
 	}
-	n, err := fmt.Fprintf(w, `package %s
+	if imports != "" {
+		imports += "\n" // Separate the standard packages from yarex.
+	}
+	fmt.Fprintf(&buf, `// Code generated by yarexgen %s. DO NOT EDIT.
+
+package %s
 
 	import (
-		"strconv"
-		"unsafe"
-		%s
-		"github.com/Maki-Daisuke/go-yarex"
+		%s"github.com/Maki-Daisuke/go-yarex"
 	)
 
-	`, gg.pkgname, importUtf8)
-	acc += int64(n)
-	if err != nil {
-		return acc, err
-	}
+	`, generatorVersion(), gg.pkgname, imports)
 
-	for _, cr := range gg.charClasses {
-		n, err := fmt.Fprintf(w, "var %s = ", cr.id)
-		acc += int64(n)
-		if err != nil {
-			return acc, err
+	// Write everything in sorted order, so that the output is reproducible.
+	for _, k := range sortedKeys(gg.charClasses) {
+		cr := gg.charClasses[k]
+		fmt.Fprintf(&buf, "\n// %s is %s.\nvar %s = ", cr.id, cr.doc, cr.id)
+		cr.code.WriteTo(&buf)
+		buf.WriteString("\n")
+	}
+	for _, k := range sortedKeys(gg.funcs) {
+		f := gg.funcs[k]
+		ptns := sortedKeys(f.ptns)
+		buf.WriteString(funcComment(f.id, false, ptns...))
+		if c, ok := codes[k]; ok {
+			c.WriteTo(&buf)
+		} else {
+			f.code.WriteTo(&buf)
 		}
-		m, err := cr.code.WriteTo(w)
-		acc += m
-		if err != nil {
-			return acc, err
+		for _, p := range ptns {
+			fmt.Fprintf(&buf, "var _ = "+f.register+"\n", p)
 		}
-		n, err = fmt.Fprintf(w, "\n")
-		acc += int64(n)
-		if err != nil {
-			return acc, err
+	}
+	for _, k := range sortedKeys(tails) {
+		t := tails[k]
+		var users []string
+		for _, u := range t.users {
+			users = append(users, sortedKeys(gg.funcs[u].ptns)...)
+		}
+		sort.Strings(users)
+		fmt.Fprintf(&buf, "\n// %s matches %q, the common tail of the patterns:", t.id, k)
+		for _, p := range users {
+			fmt.Fprintf(&buf, "\n//   - %q", p)
 		}
+		t.code.WriteTo(&buf)
+	}
+	for _, k := range sortedKeys(gg.sets) {
+		gg.sets[k].WriteTo(&buf)
+	}
+	for _, name := range sortedKeys(gg.named) {
+		gg.writeTyped(&buf, name, gg.named[name])
 	}
 
-	for _, f := range gg.funcs {
-		n, err := f.WriteTo(w)
-		acc += n
-		if err != nil {
-			return acc, err
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return 0, fmt.Errorf("(THIS SHOULD NOT HAPPEN) can't format generated code: %w", err)
+	}
+	n, err := w.Write(src)
+	return int64(n), err
+}
+
+// generatorVersion returns the version of this module used to generate code,
+// which is written in the header of generated files.
+func generatorVersion() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(unknown)"
+	}
+	if bi.Main.Path == modulePath {
+		return bi.Main.Version
+	}
+	for _, m := range bi.Deps {
+		if m.Path == modulePath {
+			return m.Version
 		}
 	}
+	return "(devel)"
+}
 
-	return acc, nil
+// sortedKeys returns the keys of m in sorted order. m must be a map with string keys.
+func sortedKeys(m interface{}) []string {
+	v := reflect.ValueOf(m)
+	keys := make([]string, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 func (gg *GoGenerator) newState() uint {
@@ -107,9 +260,19 @@ func (gg *GoGenerator) newState() uint {
 	return gg.stateCount
 }
 
-func (gg *GoGenerator) newId() string {
-	gg.idCount++
-	return fmt.Sprintf("%s%d", gg.idPrefix, gg.idCount)
+// newId returns an identifier of the kind of object for key. It is derived from
+// key, so that it does not change when other patterns are added or removed, and
+// the same identifier is returned for the same kind and key.
+func (gg *GoGenerator) newId(kind, key string) string {
+	kk := kind + "\x00" + key
+	h := fnv.New32a()
+	io.WriteString(h, kk)
+	id := fmt.Sprintf("%s_%08x", gg.idPrefix, h.Sum32())
+	for gg.ids[id] != "" && gg.ids[id] != kk { // Hash collision, which is very unlikely.
+		id += "_"
+	}
+	gg.ids[id] = kk
+	return id
 }
 
 func (gg *GoGenerator) newRepeatID() uint {
@@ -117,23 +280,104 @@ func (gg *GoGenerator) newRepeatID() uint {
 	return gg.repeatCount
 }
 
-func (gg *GoGenerator) generateFunc(re string, ast Ast) *codeFragments {
-	funcID := gg.newId()
+const generatedSuccessCode = `
+			return ctx.Success(p, onSuccess)
+`
+
+// generateFunc generates a backtracking matcher for ast, where key is the
+// canonical pattern of ast.
+func (gg *GoGenerator) generateFunc(key string, ast Ast) *generatedFunc {
+	funcID := gg.newId("func", key)
+	args := fmt.Sprintf("%t, %d, %s", canOnlyMatchAtBegining(ast), minRequiredLengthOfAst(ast), funcID)
+	return &generatedFunc{
+		id:          funcID,
+		ast:         ast,
+		code:        gg.generateMatcher(funcID, ast, &codeFragments{0, generatedSuccessCode, nil}, 0),
+		register:    "yarex.RegisterCompiledRegexp(%[1]q, " + args + ")",
+		constructor: "yarex.NewCompiledRegexp(%[1]q, " + args + ")",
+		ptns:        map[string]bool{},
+	}
+}
+
+// generateMatcher generates a backtracking matcher function funcID, which
+// matches ast followed by next. The repeats in ast are numbered after
+// repeatBase.
+func (gg *GoGenerator) generateMatcher(funcID string, ast Ast, next *codeFragments, repeatBase uint) *codeFragments {
 	gg.stateCount = 0
+	gg.repeatCount = repeatBase
 	gg.useCharClass = false
 	gg.useSmallLoop = false
-	follower := gg.generateAst(funcID, ast, &codeFragments{0, fmt.Sprintf(`
-			onSuccess(ctx.Push(yarex.ContextKey{'c', 0}, p))
+	next.follower = gg.generateFuncFooter()
+	follower := gg.generateAst(funcID, ast, next)
+	return follower.prepend(gg.generateFuncHeader(funcID))
+}
+
+// generateSetFunc generates a single function for all members of a Set.
+// Each member has its own entry state, and state 0 tries all the members not
+// matched yet, as setExecer does.
+func (gg *GoGenerator) generateSetFunc(rs []string, asts []Ast) *codeFragments {
+	funcID := gg.newId("set", setKey(rs))
+	gg.stateCount = 0
+	gg.repeatCount = 0
+	gg.useCharClass = false
+	gg.useSmallLoop = false
+	// Generate code from the last member, since codeFragments are built backward.
+	footer := gg.generateFuncFooter()
+	follower := footer
+	entries := make([]uint, len(asts))
+	for i := len(asts) - 1; i >= 0; i-- {
+		follower = gg.generateAst(funcID, asts[i], &codeFragments{0, fmt.Sprintf(`
+			onSuccess(ctx.Push(yarex.ContextKey{'s', %d}, p))
 			return true
-		default:
-			// This should not happen.
-			panic("state" + strconv.Itoa(state) + "is not defined")
+		`, i), follower})
+		entries[i] = gg.newState()
+		follower = follower.prepend(fmt.Sprintf("case %d:\n", entries[i]))
+	}
+	var tries strings.Builder
+	tries.WriteString("ok := false\n")
+	for i, ast := range asts {
+		conds := []string{}
+		if canOnlyMatchAtBegining(ast) {
+			conds = append(conds, "p == 0")
 		}
+		if m := minRequiredLengthOfAst(ast); m > 0 {
+			conds = append(conds, fmt.Sprintf("len(str)-p >= %d", m))
+		}
+		conds = append(conds, fmt.Sprintf("ctx.FindVal(yarex.ContextKey{'s', %d}) < 0", i))
+		conds = append(conds, fmt.Sprintf("%s(%d, ctx, p, onSuccess)", funcID, entries[i]))
+		fmt.Fprintf(&tries, "if %s {\nok = true\n}\n", strings.Join(conds, " && "))
+	}
+	tries.WriteString("return ok\n")
+	follower = follower.prepend(tries.String())
+	quoted := make([]string, len(rs))
+	for i, r := range rs {
+		quoted[i] = fmt.Sprintf("%q", r)
+	}
+	footer.code += fmt.Sprintf("var _ = yarex.RegisterCompiledSet([]string{%s}, %s)\n", strings.Join(quoted, ", "), funcID)
+	return follower.prepend(funcComment(funcID, true, rs...) + gg.generateFuncHeader(funcID))
+}
+
+// funcComment returns the doc comment of the generated function for ptns,
+// which are the members of a Set if isSet is true, or the equivalent patterns
+// sharing the function otherwise.
+func funcComment(funcID string, isSet bool, ptns ...string) string {
+	if len(ptns) == 1 {
+		return fmt.Sprintf("\n// %s matches %q.", funcID, ptns[0])
+	}
+	var b strings.Builder
+	if isSet {
+		fmt.Fprintf(&b, "\n// %s matches the Set of patterns:", funcID)
+	} else {
+		fmt.Fprintf(&b, "\n// %s matches any of the equivalent patterns:", funcID)
 	}
+	for _, p := range ptns {
+		fmt.Fprintf(&b, "\n//   - %q", p)
+	}
+	return b.String()
 }
-var _ = yarex.RegisterCompiledRegexp(%q, %t, %d, %s)
-	`, re, canOnlyMatchAtBegining(ast), minRequiredLengthOfAst(ast), funcID), nil})
 
+func (gg *GoGenerator) generateFuncHeader(funcID string) string {
+	gg.useBacktrack = true
 	varDecl := ""
 	if gg.useCharClass {
 		varDecl = `
@@ -153,29 +397,42 @@ var _ = yarex.RegisterCompiledRegexp(%q, %t, %d, %s)
 	)
 		`
 	}
-
-	return follower.prepend(fmt.Sprintf(`
+	return fmt.Sprintf(`
 func %s (state int, ctx yarex.MatchContext, p int, onSuccess func(yarex.MatchContext)) bool {
 	%s
-	str := *(*string)(unsafe.Pointer(ctx.Str))
+	str := ctx.Input()
 	for{
 		switch state {
 		case 0:
-	`, funcID, varDecl))
+	`, funcID, varDecl)
+}
+
+func (gg *GoGenerator) generateFuncFooter() *codeFragments {
+	return &codeFragments{0, `
+		default:
+			// This should not happen.
+			panic("state" + strconv.Itoa(state) + "is not defined")
+		}
+	}
+}
+	`, nil}
 }
 
 func (gg *GoGenerator) generateAst(funcID string, re Ast, follower *codeFragments) *codeFragments {
 	switch r := re.(type) {
 	case AstLit:
 		return gg.generateLit(string(r), follower)
+	case AstFoldLit:
+		return gg.generateFoldLit(string(r), follower)
 	case AstNotNewline:
 		gg.useUtf8 = true
+		gg.useCharClass = true // for r and size
 		return &codeFragments{follower.minReq + 1, fmt.Sprintf(`
 			if len(str)-p < %d {
 				return false
 			}
-			r, size := utf8.DecodeRuneInString(str[p:])
-			if size == 0 || r == utf8.RuneError {
+			r, size = utf8.DecodeRuneInString(str[p:])
+			if size == 0 {
 				return false
 			}
 			if r == '\n' {
@@ -206,7 +463,7 @@ func (gg *GoGenerator) generateAst(funcID string, re Ast, follower *codeFragment
 			}
 		`)
 	case AstCharClass:
-		return gg.generateCharClass(r.str, r.CharClass, follower)
+		return gg.generateCharClass(r.CharClass, follower)
 	default:
 		panic(fmt.Errorf("Please implement compiler for %T", re))
 	}
@@ -234,6 +491,49 @@ func (gg *GoGenerator) generateLit(str string, follower *codeFragments) *codeFra
 	return &codeFragments{minReq, buf.String(), follower}
 }
 
+// generateFoldLit generates code comparing str with a literal ignoring case.
+// If the literal consists only of ASCII characters folding into ASCII, they
+// are compared byte by byte, setting the lower-case bit (0x20) of letters.
+func (gg *GoGenerator) generateFoldLit(str string, follower *codeFragments) *codeFragments {
+	if len(str) == 0 {
+		return follower
+	}
+	if !isASCIIFold(str) {
+		minReq := follower.minReq + minFoldLen(str)
+		return &codeFragments{minReq, fmt.Sprintf(`
+			if len(str)-p < %d {
+				return false
+			}
+			if p = yarex.MatchFoldLit(str, p, %q); p < 0 {
+				return false
+			}
+		`, minReq, str), follower}
+	}
+	minReq := follower.minReq + len(str)
+	mask := foldMask(str)
+	var buf strings.Builder
+	fmt.Fprintf(&buf, `if len(str)-p < %d {
+		return false
+	}
+	if !(`, minReq)
+	for i := 0; i < len(str); i++ {
+		if i > 0 {
+			buf.WriteString(" && ")
+		}
+		if mask[i] != 0 {
+			fmt.Fprintf(&buf, "str[p+%d]|0x20 == %d", i, str[i]|mask[i])
+		} else {
+			fmt.Fprintf(&buf, "str[p+%d] == %d", i, str[i])
+		}
+	}
+	fmt.Fprintf(&buf, `) {
+		return false
+	}
+	p += %d
+	`, len(str))
+	return &codeFragments{minReq, buf.String(), follower}
+}
+
 func (gg *GoGenerator) generateSeq(funcID string, seq []Ast, follower *codeFragments) *codeFragments {
 	if len(seq) == 0 {
 		return follower
@@ -296,6 +596,9 @@ func (gg *GoGenerator) generateRepeat(funcID string, re Ast, min, max int, follo
 	case AstCharClass:
 		return gg.generateRepeatCharClass(funcID, r, min, max, follower)
 	}
+	if isLargeRepeat(min, max) {
+		return gg.generateCountedRepeat(funcID, re, min, max, follower)
+	}
 	if min > 0 {
 		return gg.generateAst(funcID, re, gg.generateRepeat(funcID, re, min-1, max-1, follower))
 	}
@@ -363,6 +666,57 @@ func (gg *GoGenerator) generateRepeat(funcID string, re Ast, min, max int, follo
 	`, startState))
 }
 
+// generateCountedRepeat generates a loop matching re{min,max}, which counts
+// the repeats in ctx, instead of unrolling re.
+func (gg *GoGenerator) generateCountedRepeat(funcID string, re Ast, min, max int, follower *codeFragments) *codeFragments {
+	repeatID := gg.newRepeatID()
+	loopState := gg.newState()
+	bodyState := gg.newState()
+	followerState := gg.newState()
+	minReq := follower.minReq
+	follower = follower.prepend(fmt.Sprintf(`
+		state = %d
+	case %d:
+	`, loopState, followerState))
+	follower = gg.generateAst(funcID, re, follower)
+	init := ""
+	zeroCheck := ""
+	push := fmt.Sprintf("ctx = ctx.Push(yarex.ContextKey{'n', %d}, ctx.FindVal(yarex.ContextKey{'n', %d})+1)\n", repeatID, repeatID)
+	if canMatchZeroWidth(re) { // If re can matches zero-width string, we need zero-width check
+		init = fmt.Sprintf("ctx = ctx.Push(yarex.ContextKey{'r', %d}, -1)\n", repeatID)
+		zeroCheck = fmt.Sprintf(`
+			if ctx.FindVal(yarex.ContextKey{'r', %d}) == p { // This means zero-width matching occurs.
+				state = %d // So, terminate repeating.
+				continue
+			}
+		`, repeatID, followerState)
+		push += fmt.Sprintf("ctx = ctx.Push(yarex.ContextKey{'r', %d}, p)\n", repeatID)
+	}
+	maxCond := ""
+	if max >= 0 {
+		maxCond = fmt.Sprintf("n < %d && ", max)
+	}
+	follower = follower.prepend(fmt.Sprintf(`
+		ctx = ctx.Push(yarex.ContextKey{'n', %[1]d}, 0)
+		%[2]s
+		fallthrough
+	case %[3]d:
+		if n := ctx.FindVal(yarex.ContextKey{'n', %[1]d}); n >= %[4]d {
+			%[5]s
+			if %[6]s%[7]s(%[8]d, ctx, p, onSuccess) {
+				return true
+			}
+			state = %[9]d
+			continue
+		}
+		fallthrough
+	case %[8]d:
+		%[10]s
+	`, repeatID, init, loopState, min, zeroCheck, maxCond, funcID, bodyState, followerState, push))
+	follower.minReq = minReq
+	return follower
+}
+
 func (gg *GoGenerator) generateRepeatLit(funcID string, lit string, min, max int, follower *codeFragments) *codeFragments {
 	gg.useSmallLoop = true
 	followerState := gg.newState()
@@ -371,6 +725,21 @@ func (gg *GoGenerator) generateRepeatLit(funcID string, lit string, min, max int
 	if max >= 0 {
 		maxCond = fmt.Sprintf(`n < %d && `, max)
 	}
+	// Fail if the number of repetitions does not reach min.
+	minCheck, minCheckHeap := "", ""
+	if min > 0 {
+		minCheck = fmt.Sprintf(`
+		if n < %d {
+			return false
+		}
+		`, min)
+		minCheckHeap = fmt.Sprintf(`
+		if n < %d {
+			yarex.IntStackPool.Put(heapStack)
+			return false
+		}
+		`, min)
+	}
 	conds := []string{}
 	for i := 0; i < len(lit); i++ {
 		conds = append(conds, fmt.Sprintf(`str[p+%d] != %d`, i, lit[i]))
@@ -390,6 +759,7 @@ func (gg *GoGenerator) generateRepeatLit(funcID string, lit string, min, max int
 			n++
 			p += %d
 		}
+		%s
 		for n > %d {  // try backtrack
 			if %s(%d, ctx, p, onSuccess) {
 				return true
@@ -417,6 +787,7 @@ func (gg *GoGenerator) generateRepeatLit(funcID string, lit string, min, max int
 			n++
 			p += %d
 		}
+		%s
 		for n > %d {  // try backtrack
 			if %s(%d, ctx, p, onSuccess) {
 				yarex.IntStackPool.Put(heapStack)
@@ -429,29 +800,40 @@ func (gg *GoGenerator) generateRepeatLit(funcID string, lit string, min, max int
 	LABEL_END%d:
 		fallthrough
 	case %d:
-	`, minReq, maxCond, condition, followerState, len(lit), min, funcID, followerState, followerState, followerState, len(lit), maxCond, condition, len(lit), min, funcID, followerState, followerState, followerState))
+	`, minReq, maxCond, condition, followerState, len(lit), minCheck, min, funcID, followerState, followerState, followerState, len(lit), maxCond, condition, len(lit), minCheckHeap, min, funcID, followerState, followerState, followerState))
 }
 
 func (gg *GoGenerator) generateRepeatCharClass(funcID string, re AstCharClass, min, max int, follower *codeFragments) *codeFragments {
-	gg.generateCharClass(re.str, re.CharClass, follower) // Compile and register CharClass
-	ccId := gg.charClasses[re.str].id                    // Get CharClass's identifier
+	gg.useSmallLoop = true
+	gg.useCharClass = true
+	gg.useUtf8 = true
+	decode := decodeClassChar(gg.generateClassCond(re.CharClass), isAsciiClass(re.CharClass), "break")
 	followerState := gg.newState()
 	minReq := follower.minReq + 1
 	maxCond := ""
 	if max >= 0 {
 		maxCond = fmt.Sprintf(`n < %d && `, max)
 	}
+	// Fail if the number of repetitions does not reach min.
+	minCheck, minCheckHeap := "", ""
+	if min > 0 {
+		minCheck = fmt.Sprintf(`
+		if n < %d {
+			return false
+		}
+		`, min)
+		minCheckHeap = fmt.Sprintf(`
+		if n < %d {
+			yarex.IntStackPool.Put(heapStack)
+			return false
+		}
+		`, min)
+	}
 	return follower.prepend(fmt.Sprintf(`
 		endPos = len(str) - %d
 		n = 0
 		for %s p <= endPos {
-			r, size = utf8.DecodeRuneInString(str[p:])
-			if size == 0 || r == utf8.RuneError {
-				break
-			}
-			if !%s.Contains(r) {
-				break
-			}
+			%s
 			if len(localStack) == n {
 				goto LABEL_HEAP_STACK%d
 			}
@@ -459,6 +841,7 @@ func (gg *GoGenerator) generateRepeatCharClass(funcID string, re AstCharClass, m
 			n++
 			p += size
 		}
+		%s
 		for n > %d {  // try backtrack
 			if %s(%d, ctx, p, onSuccess) {
 				return true
@@ -474,13 +857,7 @@ func (gg *GoGenerator) generateRepeatCharClass(funcID string, re AstCharClass, m
 		n++
 		p += size
 		for %s p <= endPos {
-			r, size = utf8.DecodeRuneInString(str[p:])
-			if size == 0 || r == utf8.RuneError {
-				break
-			}
-			if !%s.Contains(r) {
-				break
-			}
+			%s
 			if len(*heapStack) == n {
 				*heapStack = append(*heapStack, p)
 				*heapStack = (*heapStack)[:cap(*heapStack)]
@@ -490,6 +867,7 @@ func (gg *GoGenerator) generateRepeatCharClass(funcID string, re AstCharClass, m
 			n++
 			p += size
 		}
+		%s
 		for n > %d {  // try backtrack
 			if %s(%d, ctx, p, onSuccess) {
 				yarex.IntStackPool.Put(heapStack)
@@ -502,7 +880,7 @@ func (gg *GoGenerator) generateRepeatCharClass(funcID string, re AstCharClass, m
 	LABEL_END%d:
 		fallthrough
 	case %d:
-	`, minReq, maxCond, ccId, followerState, min, funcID, followerState, followerState, followerState, maxCond, ccId, min, funcID, followerState, followerState, followerState))
+	`, minReq, maxCond, decode, followerState, minCheck, min, funcID, followerState, followerState, followerState, maxCond, decode, minCheckHeap, min, funcID, followerState, followerState, followerState))
 }
 
 func (gg *GoGenerator) compileCapture(funcID string, re Ast, index uint, follower *codeFragments) *codeFragments {
@@ -534,29 +912,36 @@ func (gg *GoGenerator) compileBackRef(index uint, follower *codeFragments) *code
 	`, index))
 }
 
-func (gg *GoGenerator) generateCharClass(ptn string, c CharClass, follower *codeFragments) *codeFragments {
-	var id string
-	if r, ok := gg.charClasses[ptn]; ok {
-		id = r.id
-	} else {
-		id = gg.newId()
-		gg.charClasses[ptn] = charClassResult{
-			id:   id,
-			code: gg.generateCharClassAux(c, nil),
-		}
-	}
+func (gg *GoGenerator) generateCharClass(c CharClass, follower *codeFragments) *codeFragments {
 	gg.useCharClass = true
+	gg.useUtf8 = true
 	return &codeFragments{follower.minReq + 1, fmt.Sprintf(`
 		if len(str)-p < %d {
 			return false
 		}
-		r, size = utf8.DecodeRuneInString(str[p:])
-		if size == 0 || r == utf8.RuneError {
-			return false
-		}
-		if !%s.Contains(r) {
-			return false
-		}
+		%s
 		p += size
-	`, follower.minReq+1, id), follower}
+	`, follower.minReq+1, decodeClassChar(gg.generateClassCond(c), isAsciiClass(c), "return false")), follower}
+}
+
+// decodeClassChar returns the code to read the character at p into r and its
+// length into size, which executes fail if p is at the end of str or the
+// character does not satisfy cond. If isAscii is true, the class contains only
+// ASCII characters, so that UTF-8 does not need to be decoded.
+func decodeClassChar(cond string, isAscii bool, fail string) string {
+	if isAscii {
+		return fmt.Sprintf(`
+		if p >= len(str) {
+			%[2]s
+		}
+		r, size = rune(str[p]), 1
+		if r >= utf8.RuneSelf || !(%[1]s) {
+			%[2]s
+		}`, cond, fail)
+	}
+	return fmt.Sprintf(`
+		r, size = utf8.DecodeRuneInString(str[p:])
+		if size == 0 || !(%[1]s) {
+			%[2]s
+		}`, cond, fail)
 }
@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
+// generateCharClassAux generates the Go expression constructing cc, which is
+// stored in a package variable of generated code.
 func (gg *GoGenerator) generateCharClassAux(cc CharClass, follower *codeFragments) *codeFragments {
 	switch c := cc.(type) {
 	case AsciiMaskClass:
@@ -16,7 +19,10 @@ func (gg *GoGenerator) generateCharClassAux(cc CharClass, follower *codeFragment
 		return gg.generateRangeTableClass(c, follower)
 	case CompClass:
 		return gg.generateCompClass(c, follower)
+	case FoldClass:
+		return gg.generateFoldClass(c, follower)
 	case CompositeClass:
+		return gg.generateCompositeClass(c, follower)
 	}
 	panic(fmt.Errorf("Please implement compiler for %T", cc))
 }
@@ -30,27 +36,28 @@ func (gg *GoGenerator) generateCompAsciiMaskClass(c CompAsciiMaskClass, follower
 }
 
 func (gg *GoGenerator) generateRangeTableClass(c *RangeTableClass, follower *codeFragments) *codeFragments {
+	gg.useUnicode = true
 	rt := (*unicode.RangeTable)(c)
 	var buf strings.Builder
-	buf.WriteString("(*yarex.RangeTableClass)(*unicode.RangeTable{\n")
-	if rt.R16 != nil {
-		buf.WriteString("	R16: []Range16{\n")
+	buf.WriteString("(*yarex.RangeTableClass)(&unicode.RangeTable{\n")
+	if len(rt.R16) > 0 {
+		buf.WriteString("R16: []unicode.Range16{\n")
 		for _, r := range rt.R16 {
-			fmt.Fprintf(&buf, "		{0x%04x, 0x%04x, %d},\n", r.Lo, r.Hi, r.Stride)
+			fmt.Fprintf(&buf, "{0x%04x, 0x%04x, %d},\n", r.Lo, r.Hi, r.Stride)
 		}
-		buf.WriteString("	},\n")
+		buf.WriteString("},\n")
 	}
-	if rt.R32 != nil {
-		buf.WriteString("	R32: []Range32{\n")
+	if len(rt.R32) > 0 {
+		buf.WriteString("R32: []unicode.Range32{\n")
 		for _, r := range rt.R32 {
-			fmt.Fprintf(&buf, "		{0x%x, 0x%x, %d},\n", r.Lo, r.Hi, r.Stride)
+			fmt.Fprintf(&buf, "{0x%x, 0x%x, %d},\n", r.Lo, r.Hi, r.Stride)
 		}
-		buf.WriteString("	},\n")
+		buf.WriteString("},\n")
 	}
 	if rt.LatinOffset != 0 {
-		fmt.Fprintf(&buf, "		LatinOffset: %d,\n", rt.LatinOffset)
+		fmt.Fprintf(&buf, "LatinOffset: %d,\n", rt.LatinOffset)
 	}
-	buf.WriteString("})\n")
+	buf.WriteString("})")
 	return &codeFragments{1, buf.String(), follower}
 }
 
@@ -62,13 +69,144 @@ func (gg *GoGenerator) generateCompClass(c CompClass, follower *codeFragments) *
 	}
 }
 
+func (gg *GoGenerator) generateFoldClass(c FoldClass, follower *codeFragments) *codeFragments {
+	return &codeFragments{
+		1,
+		`yarex.FoldClass{`,
+		gg.generateCharClassAux(c.CharClass, follower.prepend("}")),
+	}
+}
+
 func (gg *GoGenerator) generateCompositeClass(c CompositeClass, follower *codeFragments) *codeFragments {
-	follower = follower.prepend(")")
+	follower = follower.prepend("}")
 	cs := ([]CharClass)(c)
-	follower = gg.generateCharClassAux(cs[len(cs)-1], follower)
-	for _, c := range cs[0 : len(cs)-1] {
-		follower = follower.prepend(", ")
-		follower = gg.generateCharClassAux(c, follower)
+	for i := len(cs) - 1; i >= 0; i-- {
+		follower = gg.generateCharClassAux(cs[i], follower)
+		if i > 0 {
+			follower = follower.prepend(", ")
+		}
+	}
+	return follower.prepend("yarex.CompositeClass{")
+}
+
+// maxInlineRanges is the maximum number of ranges of characters, either ASCII or
+// non-ASCII, to be checked by inline comparisons in generated code. Classes with
+// more ranges are checked by a lookup table for ASCII characters, and by
+// unicode.Is for the others.
+const maxInlineRanges = 4
+
+// isAsciiClass reports whether c contains only ASCII characters, for which
+// generated code does not need to decode UTF-8.
+func isAsciiClass(c CharClass) bool {
+	rs, ok := classRanges(c)
+	return ok && (len(rs) == 0 || rs[len(rs)-1].hi < utf8.RuneSelf)
+}
+
+// generateClassCond returns the Go expression reporting whether the rune in
+// the variable r is contained in c. If c is an ASCII class, r must be less than
+// utf8.RuneSelf.
+func (gg *GoGenerator) generateClassCond(c CharClass) string {
+	rs, ok := classRanges(c)
+	if !ok {
+		return gg.registerCharClass(c) + ".Contains(r)"
+	}
+	var ascii, other []runeRange
+	for _, rr := range rs {
+		if rr.lo < utf8.RuneSelf {
+			hi := rr.hi
+			if hi >= utf8.RuneSelf {
+				hi = utf8.RuneSelf - 1
+			}
+			ascii = append(ascii, runeRange{rr.lo, hi})
+		}
+		if rr.hi >= utf8.RuneSelf {
+			lo := rr.lo
+			if lo < utf8.RuneSelf {
+				lo = utf8.RuneSelf
+			}
+			other = append(other, runeRange{lo, rr.hi})
+		}
+	}
+	var conds []string
+	if len(ascii) > maxInlineRanges {
+		id := gg.registerAsciiTable(ascii)
+		if len(other) == 0 {
+			conds = append(conds, fmt.Sprintf("%s[uint8(r)]", id)) // r < utf8.RuneSelf is assured.
+		} else {
+			conds = append(conds, fmt.Sprintf("r < utf8.RuneSelf && %s[uint8(r)]", id))
+		}
+	} else {
+		for _, rr := range ascii {
+			conds = append(conds, rangeCond(rr))
+		}
+	}
+	if len(other) > maxInlineRanges {
+		id := gg.registerCharClass(classOfRanges(other))
+		conds = append(conds, fmt.Sprintf("r >= utf8.RuneSelf && %s.Contains(r)", id))
+	} else {
+		for _, rr := range other {
+			conds = append(conds, rangeCond(rr))
+		}
+	}
+	if len(conds) == 0 {
+		return "false"
+	}
+	return strings.Join(conds, " || ")
+}
+
+// rangeCond returns the Go expression reporting whether the rune in r is in rr.
+func rangeCond(rr runeRange) string {
+	switch {
+	case rr.lo == rr.hi:
+		return fmt.Sprintf("r == %s", runeLit(rr.lo))
+	case rr.lo == 0 && rr.hi == unicode.MaxRune:
+		return "true"
+	case rr.lo == 0:
+		return fmt.Sprintf("r <= %s", runeLit(rr.hi))
+	case rr.hi == unicode.MaxRune:
+		return fmt.Sprintf("r >= %s", runeLit(rr.lo))
+	}
+	return fmt.Sprintf("r >= %s && r <= %s", runeLit(rr.lo), runeLit(rr.hi))
+}
+
+// runeLit returns the Go literal of r, which is a quoted character for ASCII,
+// or a hexadecimal number otherwise, since r may not be a valid character.
+func runeLit(r rune) string {
+	if r < utf8.RuneSelf {
+		return fmt.Sprintf("%q", r)
+	}
+	return fmt.Sprintf("0x%04x", r)
+}
+
+// registerAsciiTable registers the lookup table of ASCII characters in ascii,
+// and returns its identifier. Tables of the same characters are shared.
+func (gg *GoGenerator) registerAsciiTable(ascii []runeRange) string {
+	doc := "the lookup table of ASCII characters in " + classOfRanges(ascii).String()
+	if r, ok := gg.charClasses[doc]; ok {
+		return r.id
+	}
+	var buf strings.Builder
+	buf.WriteString("[256]bool{\n")
+	for _, rr := range ascii {
+		for r := rr.lo; r <= rr.hi; r++ {
+			fmt.Fprintf(&buf, "%q: true, ", r)
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("}")
+	id := gg.newId("table", doc)
+	gg.charClasses[doc] = charClassResult{id, doc, &codeFragments{0, buf.String(), nil}}
+	return id
+}
+
+// registerCharClass registers the variable of c, and returns its identifier.
+// Variables of the same class are shared.
+func (gg *GoGenerator) registerCharClass(c CharClass) string {
+	doc := "the char class of " + c.String()
+	if r, ok := gg.charClasses[doc]; ok {
+		return r.id
 	}
-	return follower.prepend("yarex.ComopsiteClass(")
+	id := gg.newId("class", doc)
+	gg.charClasses[doc] = charClassResult{id, doc, gg.generateCharClassAux(c, nil)}
+	return id
 }
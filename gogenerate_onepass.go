@@ -0,0 +1,121 @@
+package yarex
+
+import (
+	"fmt"
+	"strings"
+)
+
+// generateOnePassFunc generates a matcher for a one-pass pattern, which scans
+// a string only once without backtracking nor pushing to stack. key is the
+// canonical pattern of ast.
+func (gg *GoGenerator) generateOnePassFunc(key string, ast Ast) *generatedFunc {
+	funcID := gg.newId("func", key)
+	prog := newOnePassProg(ast)
+	var buf strings.Builder
+	fmt.Fprintf(&buf, `
+func %s(str string, onSuccess func(yarex.MatchContext)) bool {
+	var (
+		r    rune
+		size int
+		caps [%d]int
+	)
+	_, _ = r, size
+	for i := range caps {
+		caps[i] = -1
+	}
+	p := 0
+	`, funcID, 2*prog.nCaps)
+	gg.generateOnePassNode(funcID, &buf, prog.node)
+	fmt.Fprintf(&buf, `
+	caps[0] = 0
+	caps[1] = p
+	yarex.OnePassSucceed(str, caps[:], onSuccess)
+	return true
+}
+	`)
+	args := fmt.Sprintf("%d, %s", prog.minReq, funcID)
+	return &generatedFunc{
+		id:          funcID,
+		code:        &codeFragments{prog.minReq, buf.String(), nil},
+		register:    "yarex.RegisterCompiledOnePass(%[1]q, " + args + ")",
+		constructor: "yarex.NewCompiledOnePass(%[1]q, " + args + ")",
+		ptns:        map[string]bool{},
+	}
+}
+
+func (gg *GoGenerator) generateOnePassNode(funcID string, buf *strings.Builder, node onePassNode) {
+	switch r := node.(type) {
+	case AstLit, AstFoldLit, AstNotNewline, AstCharClass, AstAssertBegin, AstAssertEnd:
+		// Leaves are the same as those of backtracking matchers. Enclose them in
+		// a block, since some of them declare variables.
+		buf.WriteString("{\n")
+		gg.generateAst(funcID, r.(Ast), &codeFragments{}).WriteTo(buf)
+		buf.WriteString("}\n")
+	case onePassSeq:
+		for _, x := range r {
+			gg.generateOnePassNode(funcID, buf, x)
+		}
+	case *onePassAlt:
+		buf.WriteString("switch {\n")
+		for i, f := range r.firsts {
+			if f.isEmpty() {
+				continue
+			}
+			fmt.Fprintf(buf, "case %s:\n", onePassCond(f))
+			gg.generateOnePassNode(funcID, buf, r.opts[i])
+		}
+		buf.WriteString("default:\n")
+		if r.dflt < 0 {
+			buf.WriteString("return false\n")
+		} else {
+			gg.generateOnePassNode(funcID, buf, r.opts[r.dflt])
+		}
+		buf.WriteString("}\n")
+	case *onePassRepeat:
+		if r.min > 0 {
+			fmt.Fprintf(buf, "for n := 0; n < %d; n++ {\n", r.min)
+			gg.generateOnePassNode(funcID, buf, r.re)
+			buf.WriteString("}\n")
+		}
+		if r.max == r.min {
+			return
+		}
+		maxCond := ""
+		if r.max > 0 {
+			maxCond = fmt.Sprintf("n < %d && ", r.max-r.min)
+		}
+		fmt.Fprintf(buf, "for n := 0; %s(%s); n++ {\n", maxCond, onePassCond(r.first))
+		gg.generateOnePassNode(funcID, buf, r.re)
+		buf.WriteString("}\n")
+	case *onePassCap:
+		buf.WriteString("{\nstart := p\n")
+		gg.generateOnePassNode(funcID, buf, r.re)
+		fmt.Fprintf(buf, "caps[%d] = start\ncaps[%d] = p\n}\n", 2*r.index, 2*r.index+1)
+	default:
+		panic(fmt.Errorf("Please implement compiler for %T", node))
+	}
+}
+
+// onePassCond returns Go expression testing if the character at p is in s.
+func onePassCond(s runeSet) string {
+	if s.any {
+		return "true"
+	}
+	conds := []string{}
+	if s.eof {
+		conds = append(conds, "p == len(str)")
+	}
+	if s.ascii.Lo != 0 {
+		conds = append(conds, fmt.Sprintf("p < len(str) && str[p] < 64 && (uint64(0x%X)>>str[p])&1 != 0", s.ascii.Lo))
+	}
+	if s.ascii.Hi != 0 {
+		conds = append(conds, fmt.Sprintf("p < len(str) && 64 <= str[p] && str[p] < 128 && (uint64(0x%X)>>(str[p]-64))&1 != 0", s.ascii.Hi))
+	}
+	if s.nonASCII {
+		conds = append(conds, "p < len(str) && str[p] >= 128")
+	}
+	if len(conds) == 0 {
+		return "false"
+	}
+	return strings.Join(conds, " || ")
+}
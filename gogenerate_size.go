@@ -0,0 +1,63 @@
+package yarex
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultMaxCodeSize is the default limit of the size of the code generated
+// for each pattern or Set in bytes.
+const DefaultMaxCodeSize = 256 << 10
+
+// SetMaxCodeSize sets the limit of the size of the code generated for each
+// pattern or Set in bytes. The patterns exceeding the limit are not compiled
+// into Go code, so that Compile and CompileSet fall back to the matchers
+// compiled at runtime. n <= 0 means no limit.
+func (gg *GoGenerator) SetMaxCodeSize(n int) {
+	gg.maxCodeSize = n
+}
+
+// CodeSizeError is returned by GoGenerator when the code generated for
+// Patterns exceeds the limit. This is rather a warning, since the patterns
+// still work by compiling them at runtime.
+type CodeSizeError struct {
+	Patterns []string
+	Size     int // size of the generated code in bytes
+	Limit    int
+}
+
+func (e *CodeSizeError) Error() string {
+	quoted := make([]string, len(e.Patterns))
+	for i, p := range e.Patterns {
+		quoted[i] = fmt.Sprintf("%q", p)
+	}
+	return fmt.Sprintf("generated code for %s is %d bytes, which exceeds the limit of %d bytes; it will be compiled at runtime instead",
+		strings.Join(quoted, ", "), e.Size, e.Limit)
+}
+
+// limitCodeSize calls generate to generate code for ptns. If the generated code
+// exceeds the limit, it discards everything registered by generate, and returns
+// *CodeSizeError.
+func (gg *GoGenerator) limitCodeSize(ptns []string, generate func() *codeFragments) error {
+	useUtf8, useUnicode, useBacktrack := gg.useUtf8, gg.useUnicode, gg.useBacktrack
+	classes := make(map[string]bool, len(gg.charClasses))
+	for k := range gg.charClasses {
+		classes[k] = true
+	}
+	ids := make(map[string]string, len(gg.ids))
+	for id, kk := range gg.ids {
+		ids[id] = kk
+	}
+	size := generate().codeLength()
+	if gg.maxCodeSize <= 0 || size <= gg.maxCodeSize {
+		return nil
+	}
+	gg.useUtf8, gg.useUnicode, gg.useBacktrack = useUtf8, useUnicode, useBacktrack
+	for k := range gg.charClasses {
+		if !classes[k] {
+			delete(gg.charClasses, k)
+		}
+	}
+	gg.ids = ids // so that the IDs do not depend on the discarded code
+	return &CodeSizeError{append([]string{}, ptns...), size, gg.maxCodeSize}
+}
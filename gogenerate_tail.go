@@ -0,0 +1,142 @@
+package yarex
+
+import "fmt"
+
+// minSharedTailSize is the minimum size of the code of a tail in bytes to share
+// it between patterns. Smaller tails are cheaper to inline than to call.
+const minSharedTailSize = 512
+
+// sharedTail is a sub-matcher generated for the common tail of patterns, which
+// their matcher functions call to match the rest of the input.
+type sharedTail struct {
+	id         string
+	ast        Ast
+	users      []string // keys of gg.funcs calling the tail
+	repeatBase uint     // the largest repeat ID used by the users before calling
+	code       *codeFragments
+}
+
+// tailCandidate is a tail of a backtracking matcher, which is ast.seq[index:].
+type tailCandidate struct {
+	key   string
+	index int
+}
+
+// shareTails finds the tails of backtracking matchers that are common to two
+// or more patterns, and generates them as sub-matchers. It returns the tails by
+// their canonical patterns, and the code of the matchers calling them, which
+// replaces that generated by Add, by the keys of gg.funcs.
+//
+// It is called by WriteTo, so that the result does not depend on the order of
+// the added patterns.
+func (gg *GoGenerator) shareTails() (map[string]*sharedTail, map[string]*codeFragments) {
+	cands := map[string][]tailCandidate{} // from the longest to the shortest
+	users := map[string]int{}
+	asts := map[string]Ast{}
+	for _, k := range sortedKeys(gg.funcs) {
+		seq, ok := gg.funcs[k].ast.(*AstSeq)
+		if !ok || len(seq.seq) < 2 {
+			continue
+		}
+		// Captures are not shared, since their indices differ between patterns.
+		start := len(seq.seq)
+		for start > 1 && !hasCapture(seq.seq[start-1]) {
+			start--
+		}
+		for i := start; i < len(seq.seq); i++ {
+			t := tailOf(seq.seq[i:])
+			key := t.String()
+			cands[k] = append(cands[k], tailCandidate{key, i})
+			users[key]++
+			asts[key] = t
+		}
+	}
+
+	// Each matcher calls the longest tail shared with others. A tail can lose
+	// its users to longer tails, so repeat until every tail has two or more.
+	dropped := map[string]bool{}
+	for key, n := range users {
+		if n < 2 || gg.generateAst("", asts[key], &codeFragments{0, generatedSuccessCode, nil}).codeLength() < minSharedTailSize {
+			dropped[key] = true
+		}
+	}
+	var chosen map[string]tailCandidate
+	var tailUsers map[string][]string
+	for {
+		chosen = map[string]tailCandidate{}
+		tailUsers = map[string][]string{}
+		for _, k := range sortedKeys(cands) {
+			for _, c := range cands[k] {
+				if !dropped[c.key] {
+					chosen[k] = c
+					tailUsers[c.key] = append(tailUsers[c.key], k)
+					break
+				}
+			}
+		}
+		done := true
+		for key, us := range tailUsers {
+			if len(us) < 2 {
+				dropped[key] = true
+				done = false
+			}
+		}
+		if done {
+			break
+		}
+	}
+
+	tails := map[string]*sharedTail{}
+	for _, key := range sortedKeys(tailUsers) {
+		tails[key] = &sharedTail{id: gg.newId("tail", key), ast: asts[key], users: tailUsers[key]}
+	}
+	codes := map[string]*codeFragments{}
+	for _, k := range sortedKeys(chosen) {
+		f := gg.funcs[k]
+		c := chosen[k]
+		t := tails[c.key]
+		call := &codeFragments{minRequiredLengthOfAst(t.ast), fmt.Sprintf("return %s(0, ctx, p, onSuccess)\n", t.id), nil}
+		codes[k] = gg.generateMatcher(f.id, &AstSeq{f.ast.(*AstSeq).seq[:c.index]}, call, 0)
+		if gg.repeatCount > t.repeatBase {
+			t.repeatBase = gg.repeatCount
+		}
+	}
+	for _, key := range sortedKeys(tails) {
+		t := tails[key]
+		// Number the repeats in the tail after those of the users, so that it
+		// does not find their values in ctx as its own.
+		t.code = gg.generateMatcher(t.id, t.ast, &codeFragments{0, generatedSuccessCode, nil}, t.repeatBase)
+	}
+	return tails, codes
+}
+
+// tailOf returns Ast matching the sequence of seq.
+func tailOf(seq []Ast) Ast {
+	if len(seq) == 1 {
+		return seq[0]
+	}
+	return &AstSeq{seq}
+}
+
+// hasCapture reports whether re contains any capturing group.
+func hasCapture(re Ast) bool {
+	switch r := re.(type) {
+	case *AstCap:
+		return true
+	case *AstSeq:
+		for _, s := range r.seq {
+			if hasCapture(s) {
+				return true
+			}
+		}
+	case *AstAlt:
+		for _, o := range r.opts {
+			if hasCapture(o) {
+				return true
+			}
+		}
+	case *AstRepeat:
+		return hasCapture(r.re)
+	}
+	return false
+}
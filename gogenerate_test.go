@@ -0,0 +1,371 @@
+package yarex_test
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/Maki-Daisuke/go-yarex"
+)
+
+func generate(t *testing.T, add func(gg *yarex.GoGenerator) error) string {
+	gg := yarex.NewGoGenerator("gogenerate_test.go", "yarex_test")
+	if err := add(gg); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := gg.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestGoGeneratorOutput(t *testing.T) {
+	ptns := []string{`a+b`, `^x[0-9]`, `(foo|bar)*baz`, `[α-ω]\w`}
+	out1 := generate(t, func(gg *yarex.GoGenerator) error {
+		if err := gg.AddSet("s", "t"); err != nil {
+			return err
+		}
+		return gg.Add(ptns...)
+	})
+	out2 := generate(t, func(gg *yarex.GoGenerator) error {
+		for i := len(ptns) - 1; i >= 0; i-- {
+			if err := gg.Add(ptns[i]); err != nil {
+				return err
+			}
+		}
+		return gg.AddSet("s", "t")
+	})
+	if out1 != out2 {
+		t.Errorf("output should not depend on the order of patterns, but it does:\n%s\n----\n%s", out1, out2)
+	}
+	if formatted, err := format.Source([]byte(out1)); err != nil || string(formatted) != out1 {
+		t.Errorf("output should be formatted by gofmt (err: %v):\n%s", err, out1)
+	}
+	if !regexp.MustCompile(`\A// Code generated by yarexgen \S+\. DO NOT EDIT\.\n`).MatchString(out1) {
+		t.Errorf("output should start with the header of generated code:\n%s", out1)
+	}
+	if strings.Contains(out1, `"unsafe"`) {
+		t.Errorf("output should not import unsafe:\n%s", out1)
+	}
+	for _, p := range ptns {
+		if !strings.Contains(out1, " matches "+strconv.Quote(p)+".\nfunc ") {
+			t.Errorf("output should have the comment for %q just above the function:\n%s", p, out1)
+		}
+	}
+
+	// IDs must not change when another pattern is added.
+	reID := regexp.MustCompile(`(?m)^// (\w+) matches "a\+b"\.$`)
+	out3 := generate(t, func(gg *yarex.GoGenerator) error { return gg.Add(`a+b`) })
+	if id1, id3 := reID.FindStringSubmatch(out1), reID.FindStringSubmatch(out3); id1 == nil || id3 == nil || id1[1] != id3[1] {
+		t.Errorf("ID of the function for a+b should be stable, but got %q and %q", id1, id3)
+	}
+}
+
+func TestGoGeneratorInlineClass(t *testing.T) {
+	out := generate(t, func(gg *yarex.GoGenerator) error {
+		return gg.Add(`[a-z]+x`, `[\w.+-]+@`, `[^α-ω]x`, `\d{2,}[α-ωΑ-Ω]`)
+	})
+	if strings.Contains(out, ".Contains(") {
+		t.Errorf("classes with a few ranges should be checked inline, but got:\n%s", out)
+	}
+	if !strings.Contains(out, `is the lookup table of ASCII characters in [+\-.0-9A-Z_a-z].`) {
+		t.Errorf("lookup table of [\\w.+-] is not generated:\n%s", out)
+	}
+}
+
+func TestGoGeneratorShared(t *testing.T) {
+	out := generate(t, func(gg *yarex.GoGenerator) error {
+		return gg.Add(`a\d`, `a[0-9]`, `[0-9]+x`)
+	})
+	if n := strings.Count(out, "\nfunc "); n != 2 {
+		t.Errorf("equivalent patterns should share a function, but got %d functions:\n%s", n, out)
+	}
+	if !strings.Contains(out, "matches any of the equivalent patterns:\n//   - \"a[0-9]\"\n//   - \"a\\\\d\"\n") {
+		t.Errorf("output should have the comment listing the equivalent patterns:\n%s", out)
+	}
+	if n := strings.Count(out, "yarex.RegisterCompiledRegexp("); n != 3 {
+		t.Errorf("each pattern should be registered, but got %d registrations:\n%s", n, out)
+	}
+}
+
+func TestGoGeneratorSharedTail(t *testing.T) {
+	ptns := []string{`a+\d{3}-\d{4}`, `b*\d{3}-\d{4}`, `c\d{3}-\d{4}`, `xy`}
+	out := generate(t, func(gg *yarex.GoGenerator) error { return gg.Add(ptns...) })
+	m := regexp.MustCompile(`(?m)^// (\w+) matches "\(\?:\[0-9\]\{3\}-\[0-9\]\{4\}\)", the common tail of the patterns:$`).FindStringSubmatch(out)
+	if m == nil {
+		t.Fatalf("output should have the function for the common tail:\n%s", out)
+	}
+	if n := strings.Count(out, "return "+m[1]+"(0, ctx, p, onSuccess)"); n != 3 {
+		t.Errorf("the tail should be called by 3 functions, but got %d:\n%s", n, out)
+	}
+	if n := strings.Count(out, "\nfunc "); n != 5 {
+		t.Errorf("output should have 4 functions for the patterns and 1 for the tail, but got %d:\n%s", n, out)
+	}
+	rev := generate(t, func(gg *yarex.GoGenerator) error {
+		for i := len(ptns) - 1; i >= 0; i-- {
+			if err := gg.Add(ptns[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if out != rev {
+		t.Errorf("output should not depend on the order of patterns, but it does:\n%s\n----\n%s", out, rev)
+	}
+
+	// A tail of a single pattern is not shared.
+	out = generate(t, func(gg *yarex.GoGenerator) error { return gg.Add(`a+\d{3}-\d{4}`, `b*\d{3}-\d{5}`) })
+	if strings.Contains(out, "the common tail") {
+		t.Errorf("output should not have any function for tails:\n%s", out)
+	}
+}
+
+func TestGoGeneratorLargeRepeat(t *testing.T) {
+	small := generate(t, func(gg *yarex.GoGenerator) error { return gg.Add(`(?:ab|cd){1,5}x`) })
+	large := generate(t, func(gg *yarex.GoGenerator) error { return gg.Add(`(?:ab|cd){1,1000}x`) })
+	if len(large) > len(small)+100 {
+		t.Errorf("code size should not grow with the bounds of repeats, but got %d bytes for {1,5} and %d bytes for {1,1000}", len(small), len(large))
+	}
+}
+
+func TestGoGeneratorMaxCodeSize(t *testing.T) {
+	gg := yarex.NewGoGenerator("gogenerate_test.go", "yarex_test")
+	gg.SetMaxCodeSize(2000)
+	err := gg.Add(`a+b`, `(?:foo|bar|baz)+(?:qux|quux)*[α-ω]`, `x`)
+	sizeErr, ok := err.(*yarex.CodeSizeError)
+	if !ok {
+		t.Fatalf("Add should return CodeSizeError, but got %v", err)
+	}
+	if want := []string{`(?:foo|bar|baz)+(?:qux|quux)*[α-ω]`}; !reflect.DeepEqual(sizeErr.Patterns, want) || sizeErr.Limit != 2000 || sizeErr.Size <= 2000 {
+		t.Errorf("CodeSizeError should be for %q exceeding 2000 bytes, but got %v", want, sizeErr)
+	}
+	if err := gg.AddSet(`(?:foo|bar|baz)+`, `(?:qux|quux)*[α-ω]`); err == nil {
+		t.Errorf("AddSet should return CodeSizeError, but did not")
+	}
+	if _, ok := gg.AddNamed("ReLarge", `(foo|bar|baz)+(?:qux|quux)*`).(*yarex.CodeSizeError); !ok {
+		t.Errorf("AddNamed should return CodeSizeError, but did not")
+	}
+	var buf bytes.Buffer
+	if _, err := gg.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if strings.Count(out, "yarex.RegisterCompiled") != 2 || strings.Contains(out, "utf8") {
+		t.Errorf("output should not have the code exceeding the limit:\n%s", out)
+	}
+	if !strings.Contains(out, ` matches "a+b".`) || !strings.Contains(out, ` matches "x".`) {
+		t.Errorf("output should have the code of the other patterns:\n%s", out)
+	}
+	if !strings.Contains(out, "var ReLarge = ReLargeMatcher{yarex.MustCompile(\"(foo|bar|baz)+(?:qux|quux)*\")}") {
+		t.Errorf("typed matcher exceeding the limit should compile the pattern at runtime:\n%s", out)
+	}
+}
+
+const typedTestMain = `package main
+
+import "fmt"
+
+func main() {
+	s := "mail to alice@example.com:25 now"
+	fmt.Println(ReEmail.MatchString(s), ReEmail.FindString(s), ReEmail.FindStringIndex(s))
+	e, ok := ReEmail.Parse(s)
+	fmt.Println(e.User(), e.Domain(), ok)
+	fmt.Println(e.Group3())
+	e, _ = ReEmail.Parse("bob@x.org")
+	fmt.Println(e.Group3())
+	e, ok = ReEmail.Parse("nothing")
+	fmt.Printf("%q %v\n", e.User(), ok)
+	fmt.Println(e.Group3())
+	fmt.Println(ReEmail.Regexp().NumSubexp(), ReEmail)
+	d, _ := ReDate.Parse("2024-05-06")
+	fmt.Println(d.Year(), d.Group2())
+	fmt.Println(ReDate.MatchString("x2024-05-06"))
+
+	a, ok := ReAddr.Parse("to: bob@example.org.")
+	fmt.Println(a.User(), a.Host(), ok)
+	a, ok = ReAddr.Parse("nobody")
+	fmt.Printf("%q %q %v\n", a.User(), a.Host(), ok)
+	for _, s := range []string{"-12.5", "42", "+inf", "1.x"} {
+		n, ok := ReNum.Parse(s)
+		fmt.Println(opt(n.Sign()), opt(n.Int()), opt(n.Frac()), opt(n.Group4()), ok)
+	}
+}
+
+func opt(s string, ok bool) string {
+	if !ok {
+		return "<nil>"
+	}
+	return s
+}
+`
+
+const typedTestOutput = `true alice@example.com:25 [8 28]
+alice example.com true
+25 true
+ false
+"" false
+ false
+3 (?P<user>[\w.]+)@(?P<domain>\w+(?:\.\w+)+)(?::(\d+))?
+2024 05
+false
+bob example.org. true
+"" "" false
+- 12 5 <nil> true
+<nil> 42 <nil> <nil> true
++ <nil> <nil> inf true
+<nil> <nil> <nil> <nil> false
+`
+
+func TestGoGeneratorTyped(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test to build generated code in short mode")
+	}
+	gg := yarex.NewGoGenerator("typed.go", "main")
+	if err := gg.AddNamed("ReEmail", `(?P<user>[\w.]+)@(?P<domain>\w+(?:\.\w+)+)(?::(\d+))?`); err != nil {
+		t.Fatal(err)
+	}
+	if err := gg.AddNamed("ReDate", `^(?P<year>\d{4})-(\d\d)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := gg.AddNamed("ReAddr", `([a-z]+)@([a-z.]+)`, "user", "host"); err != nil {
+		t.Fatal(err)
+	}
+	if err := gg.AddNamed("ReNum", `^([+-])?(?:(\d+)(?:\.(\d+))?|(inf))$`, "sign", "int", "frac"); err != nil {
+		t.Fatal(err)
+	}
+	if err := gg.AddNamed("ReTooMany", `(a)`, "x", "y"); err == nil {
+		t.Errorf("AddNamed should fail for more names than groups, but did not")
+	}
+	if err := gg.AddNamed("ReBadGroup", `(a)`, "x-y"); err == nil {
+		t.Errorf("AddNamed should fail for invalid name of group, but did not")
+	}
+	for _, name := range []string{"reLower", "Re-Dash", ""} {
+		if err := gg.AddNamed(name, `a`); err == nil {
+			t.Errorf("AddNamed(%q) should fail, but did not", name)
+		}
+	}
+	if err := gg.AddNamed("ReDate", `b`); err == nil {
+		t.Errorf("AddNamed should fail for the name given to another pattern, but did not")
+	}
+	if err := gg.AddNamed("ReAddrMatch", `b`); err == nil {
+		t.Errorf("AddNamed should fail for the name colliding with the Match type of another, but did not")
+	}
+	if err := gg.AddNamed("ReNumMatcher", `b`); err == nil {
+		t.Errorf("AddNamed should fail for the name colliding with the Matcher type of another, but did not")
+	}
+	if err := gg.AddNamed("ReMatch", `b`); err != nil {
+		t.Fatal(err)
+	}
+	if err := gg.AddNamed("Re", `(a)`); err == nil {
+		t.Errorf("AddNamed should fail for the name whose Match type collides with another, but did not")
+	}
+
+	var buf bytes.Buffer
+	if _, err := gg.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := runGenerated(t, map[string]string{"main.go": typedTestMain, "typed_yarex.go": buf.String()})
+	if out != typedTestOutput {
+		t.Errorf("want:\n%s\nbut got:\n%s", typedTestOutput, out)
+	}
+}
+
+// runGenerated runs the main package consisting of files in a temporary module
+// depending on this repository, and returns its output.
+func runGenerated(t *testing.T, files map[string]string) string {
+	src, err := filepath.Abs(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp, err := ioutil.TempDir("", "yarexgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	files["go.mod"] = fmt.Sprintf("module generated\n\ngo 1.15\n\nrequire github.com/Maki-Daisuke/go-yarex v0.0.0\n\nreplace github.com/Maki-Daisuke/go-yarex => %s\n", src)
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(tmp, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command("go", "run", ".")
+	cmd.Dir = tmp
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("failed to run generated code: %v\n%s", err, out)
+	}
+	return string(out)
+}
+
+// charClassTestRunes are the characters to test membership of generated
+// CharClasses.
+const charClassTestRunes = "\x00 09:AZaz\x7f\u00b5\u00ff\u0391\u03a3\u03b1\u03c2\u03c3\u03c9\u212a\u65e5\U00010000\U000100ff\U00010100\U0010ffff"
+
+// TestGoGeneratorCharClass builds the generated expressions of every kind of
+// CharClass, including those which parse never produces, and compares their
+// membership with the original CharClasses.
+func TestGoGeneratorCharClass(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test to build generated code in short mode")
+	}
+	digit := yarex.AsciiMaskClass{Lo: 0x03FF000000000000}
+	greek := (*yarex.RangeTableClass)(&unicode.RangeTable{R16: []unicode.Range16{{0x03b1, 0x03c9, 1}}})
+	odd := (*yarex.RangeTableClass)(&unicode.RangeTable{
+		R16:         []unicode.Range16{{'a', 'z', 2}, {0x03b1, 0x03c9, 2}},
+		R32:         []unicode.Range32{{0x10000, 0x10100, 0xff}},
+		LatinOffset: 1,
+	})
+	classes := []yarex.CharClass{
+		digit,
+		yarex.CompAsciiMaskClass{digit},
+		greek,
+		odd,
+		yarex.CompositeClass{digit, greek},
+		yarex.CompClass{yarex.CompositeClass{digit, odd}},
+		yarex.FoldClass{yarex.CompClass{greek}},
+		yarex.FoldClass{yarex.CompositeClass{yarex.CompAsciiMaskClass{digit}, odd}},
+		yarex.CompositeClass{yarex.FoldClass{odd}, yarex.CompClass{yarex.CompositeClass{greek}}},
+	}
+	var main, want strings.Builder
+	fmt.Fprintf(&main, "package main\n\nimport (\n\"fmt\"\n\"unicode\"\n\n%q\n)\n\nvar _ = unicode.Is\n\nvar classes = []yarex.CharClass{\n", "github.com/Maki-Daisuke/go-yarex")
+	for _, c := range classes {
+		fmt.Fprintf(&main, "%s,\n", yarex.GenerateCharClass(c))
+		for _, r := range charClassTestRunes {
+			if c.Contains(r) {
+				want.WriteByte('1')
+			} else {
+				want.WriteByte('0')
+			}
+		}
+		want.WriteByte('\n')
+	}
+	fmt.Fprintf(&main, `}
+
+func main() {
+	for _, c := range classes {
+		for _, r := range %q {
+			if c.Contains(r) {
+				fmt.Print("1")
+			} else {
+				fmt.Print("0")
+			}
+		}
+		fmt.Println()
+	}
+}
+`, charClassTestRunes)
+	if out := runGenerated(t, map[string]string{"main.go": main.String()}); out != want.String() {
+		t.Errorf("generated CharClasses differ from the originals:\nwant:\n%s\nbut got:\n%s\ncode:\n%s", want.String(), out, main.String())
+	}
+}
@@ -0,0 +1,248 @@
+package yarex
+
+import (
+	"fmt"
+	"go/token"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// typedMethods are the methods of typed matchers, which are not used as the
+// names of the group accessors not to be confused with them.
+var typedMethods = map[string]bool{
+	"Regexp":          true,
+	"String":          true,
+	"MatchString":     true,
+	"FindString":      true,
+	"FindStringIndex": true,
+	"Parse":           true,
+}
+
+var reGroupAccessor = regexp.MustCompile(`^Group[0-9]+$`)
+
+// AddNamed adds ptn as Add does, and also generates an exported variable of a
+// typed matcher named name, so that callers can use the generated matcher
+// directly without Compile. The type of the variable is name+"Matcher", which
+// has MatchString, FindString and FindStringIndex as Regexp.
+//
+// If ptn has capturing groups, a type name+"Match" is also generated, and the
+// typed matcher has the method Parse(s) (name+"Match", bool), which finds the
+// leftmost match in s and stores the locations of the groups. name+"Match" has
+// an accessor for each group, which slices s at the stored location. The
+// accessors of the groups that can be unmatched return (string, bool), where
+// the bool reports whether the group is matched, and the others return string.
+//
+// The accessors are named after groups[i] for the (i+1)-th group, or the name
+// of the group if groups[i] is missing or empty, with the first letter
+// capitalized. If neither is available, they are named GroupN for the N-th
+// group.
+//
+// AddNamed fails if any of the identifiers generated for name collides with
+// those of another typed matcher, e.g. "ReMatch" and "Re" for a pattern with
+// groups both generate ReMatch.
+func (gg *GoGenerator) AddNamed(name, ptn string, groups ...string) error {
+	if !token.IsIdentifier(name) || !token.IsExported(name) {
+		return fmt.Errorf("name of typed matcher must be an exported identifier: %q", name)
+	}
+	if p, ok := gg.named[name]; ok && p.ptn != ptn {
+		return fmt.Errorf("typed matcher %s is given for both %q and %q", name, p.ptn, ptn)
+	}
+	ast, err := parse(ptn)
+	if err != nil {
+		return err
+	}
+	nsub := numSubexp(ast)
+	if len(groups) > nsub {
+		return fmt.Errorf("%d names are given for %d groups of %q", len(groups), nsub, ptn)
+	}
+	for _, g := range groups {
+		if g != "" && !token.IsIdentifier(g) {
+			return fmt.Errorf("name of group must be an identifier: %q", g)
+		}
+	}
+	for _, other := range sortedKeys(gg.named) {
+		if other == name {
+			continue
+		}
+		for _, id := range typedIdents(name, nsub) {
+			for _, oid := range typedIdents(other, gg.named[other].nsub) {
+				if id == oid {
+					return fmt.Errorf("typed matchers %s and %s both generate %s", other, name, id)
+				}
+			}
+		}
+	}
+	// If the pattern is too large to generate, the typed matcher compiles it at
+	// runtime, and CodeSizeError is returned as a warning.
+	err = gg.Add(ptn)
+	if _, ok := err.(*CodeSizeError); err != nil && !ok {
+		return err
+	}
+	gg.named[name] = typedMatcher{ptn, nsub, append([]string{}, groups...)}
+	return err
+}
+
+// typedIdents returns the identifiers generated for the typed matcher name of
+// a pattern with nsub groups.
+func typedIdents(name string, nsub int) []string {
+	if nsub == 0 {
+		return []string{name, name + "Matcher"}
+	}
+	return []string{name, name + "Matcher", name + "Match"}
+}
+
+// typedMatcher is a typed matcher added by AddNamed.
+type typedMatcher struct {
+	ptn    string
+	nsub   int      // number of the groups in ptn
+	groups []string // names of the groups given to AddNamed
+}
+
+// groupAccessors returns the names of the capture accessors for the groups of
+// tm, which are the methods of the Match type.
+func (tm typedMatcher) groupAccessors() []string {
+	ast, err := parse(tm.ptn)
+	if err != nil {
+		panic(fmt.Errorf("(THIS SHOULD NOT HAPPEN) can't parse added pattern: %w", err))
+	}
+	names := appendCaptureNames(nil, ast)
+	copy(names, nonEmpty(tm.groups, names))
+	out := make([]string, len(names))
+	used := map[string]bool{}
+	for i, n := range names {
+		acc := ""
+		if n != "" {
+			acc = strings.ToUpper(n[:1]) + n[1:]
+		}
+		if !token.IsExported(acc) || typedMethods[acc] || used[acc] || reGroupAccessor.MatchString(acc) {
+			acc = fmt.Sprintf("Group%d", i+1)
+		}
+		used[acc] = true
+		out[i] = acc
+	}
+	return out
+}
+
+// nonEmpty returns names with the empty ones replaced by those in defaults.
+func nonEmpty(names, defaults []string) []string {
+	out := make([]string, len(names))
+	for i, n := range names {
+		if n == "" {
+			n = defaults[i]
+		}
+		out[i] = n
+	}
+	return out
+}
+
+// optionalGroups reports for each group of re whether it can be unmatched when
+// re matches. required tells whether re itself is always matched.
+func optionalGroups(optional []bool, re Ast, required bool) []bool {
+	switch r := re.(type) {
+	case *AstCap:
+		optional = append(optional, !required)
+		return optionalGroups(optional, r.re, required)
+	case *AstRepeat:
+		return optionalGroups(optional, r.re, required && r.min > 0)
+	case *AstSeq:
+		for _, s := range r.seq {
+			optional = optionalGroups(optional, s, required)
+		}
+	case *AstAlt:
+		for _, o := range r.opts {
+			optional = optionalGroups(optional, o, required && len(r.opts) == 1)
+		}
+	}
+	return optional
+}
+
+// writeTyped writes the typed matcher named name.
+func (gg *GoGenerator) writeTyped(w io.Writer, name string, tm typedMatcher) {
+	ptn := tm.ptn
+	typ := name + "Matcher"
+	fmt.Fprintf(w, `
+// %[1]s is the typed matcher of %[3]q.
+var %[1]s = %[2]s{%[4]s}
+
+// %[2]s is the type of %[1]s, which calls the generated matcher directly.
+type %[2]s struct {
+	re *yarex.Regexp
+}
+
+// Regexp returns the Regexp of the generated matcher.
+func (m %[2]s) Regexp() *yarex.Regexp {
+	return m.re
+}
+
+func (m %[2]s) String() string {
+	return m.re.String()
+}
+
+func (m %[2]s) MatchString(s string) bool {
+	return m.re.MatchString(s)
+}
+
+func (m %[2]s) FindString(s string) string {
+	return m.re.FindString(s)
+}
+
+func (m %[2]s) FindStringIndex(s string) []int {
+	return m.re.FindStringIndex(s)
+}
+`, name, typ, ptn, gg.constructor(ptn))
+	accs := tm.groupAccessors()
+	if len(accs) == 0 {
+		return
+	}
+
+	ast, _ := parse(ptn)
+	optional := optionalGroups(nil, ast, true)
+	fmt.Fprintf(w, `
+// %[1]sMatch holds the locations of the groups captured by %[1]s in the
+// leftmost match, which its accessors slice the input at.
+type %[1]sMatch struct {
+	s   string
+	loc [%[3]d]int
+}
+
+// Parse finds the leftmost match in s, and returns the locations of its groups
+// and whether s matches. If s does not match, all the groups are unmatched.
+func (m %[2]s) Parse(s string) (%[1]sMatch, bool) {
+	var match yarex.Match
+	t := %[1]sMatch{s: s}
+	if !m.re.FindStringMatch(s, &match) {
+		for i := range t.loc {
+			t.loc[i] = -1
+		}
+		return t, false
+	}
+	copy(t.loc[:], match.Index()[2:])
+	return t, true
+}
+`, name, typ, 2*len(accs))
+	for i, acc := range accs {
+		if optional[i] {
+			fmt.Fprintf(w, `
+// %[2]s returns the text captured by group %[3]d, and whether the group is
+// matched.
+func (t %[1]sMatch) %[2]s() (string, bool) {
+	if t.loc[%[4]d] < 0 {
+		return "", false
+	}
+	return t.s[t.loc[%[4]d]:t.loc[%[5]d]], true
+}
+`, name, acc, i+1, 2*i, 2*i+1)
+		} else {
+			fmt.Fprintf(w, `
+// %[2]s returns the text captured by group %[3]d, or "" if s does not match.
+func (t %[1]sMatch) %[2]s() string {
+	if t.loc[%[4]d] < 0 {
+		return ""
+	}
+	return t.s[t.loc[%[4]d]:t.loc[%[5]d]]
+}
+`, name, acc, i+1, 2*i, 2*i+1)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package yarex
+
+import "fmt"
+
+// latin1Ast converts literals in re into Latin-1, so that each character in
+// the pattern matches a single byte of the same value.
+func latin1Ast(re Ast) (Ast, error) {
+	switch v := re.(type) {
+	case AstLit:
+		buf := make([]byte, 0, len(v))
+		for _, r := range string(v) {
+			if r > 0xFF {
+				return nil, fmt.Errorf("character %q can't be matched in Latin-1 mode", r)
+			}
+			buf = append(buf, byte(r))
+		}
+		return AstLit(buf), nil
+	case AstFoldLit:
+		// Each character is turned into a class of its equivalents, which may
+		// contain characters above U+00FF, but they never match any byte.
+		out := []Ast{}
+		for _, r := range string(v) {
+			if r > 0xFF {
+				return nil, fmt.Errorf("character %q can't be matched in Latin-1 mode", r)
+			}
+			c := FoldCharClass((*RangeTableClass)(rangeTableFromTo(r, r)))
+			out = append(out, AstCharClass{c, "(?i)" + string(r)})
+		}
+		return &AstSeq{out}, nil
+	case *AstSeq:
+		out := make([]Ast, len(v.seq))
+		for i, r := range v.seq {
+			x, err := latin1Ast(r)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = x
+		}
+		return &AstSeq{out}, nil
+	case *AstAlt:
+		out := make([]Ast, len(v.opts))
+		for i, r := range v.opts {
+			x, err := latin1Ast(r)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = x
+		}
+		return &AstAlt{out}, nil
+	case *AstRepeat:
+		x, err := latin1Ast(v.re)
+		if err != nil {
+			return nil, err
+		}
+		out := *v
+		out.re = x
+		return &out, nil
+	case *AstCap:
+		x, err := latin1Ast(v.re)
+		if err != nil {
+			return nil, err
+		}
+		out := *v
+		out.re = x
+		return &out, nil
+	}
+	return re, nil
+}
@@ -0,0 +1,212 @@
+// Package lex builds tokenizers from token rules written in regular expressions.
+//
+// All the rules of a Lexer are combined into a single alternation, where each
+// rule is enclosed by a capturing group, so that a token is found by one match
+// instead of trying the rules one by one. The combined pattern is returned by
+// Lexer.String. If it is compiled by yarexgen, the generated matcher is used.
+package lex
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/Maki-Daisuke/go-yarex"
+	"github.com/Maki-Daisuke/go-yarex/syntax"
+)
+
+// Rule makes a token of Type from text matching Pattern.
+type Rule struct {
+	Pattern string
+	Type    int
+}
+
+// Lexer is a set of rules compiled together.
+type Lexer struct {
+	rules  []Rule
+	re     *yarex.Regexp
+	groups []int // groups[i] is the index of the group enclosing rules[i].
+}
+
+// Compile compiles rules into a Lexer that chooses the first rule matching at
+// each position, in the order of rules, as leftmost-first matching of regexp.
+func Compile(rules ...Rule) (*Lexer, error) {
+	return compile(rules, false)
+}
+
+func MustCompile(rules ...Rule) *Lexer {
+	l, err := Compile(rules...)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// CompileLongest is like Compile, but the returned Lexer chooses the rule that
+// matches the longest text at each position, as lex does. If several rules
+// match the longest text, the first one is chosen.
+func CompileLongest(rules ...Rule) (*Lexer, error) {
+	return compile(rules, true)
+}
+
+func MustCompileLongest(rules ...Rule) *Lexer {
+	l, err := CompileLongest(rules...)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+func compile(rules []Rule, longest bool) (*Lexer, error) {
+	opts := make([]*syntax.Node, len(rules))
+	groups := make([]int, len(rules))
+	ngroup := 0
+	for i, r := range rules {
+		n, err := yarex.ParseSyntax(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("lex: rule %d (%q): %w", i, r.Pattern, err)
+		}
+		ngroup++
+		groups[i] = ngroup
+		opts[i] = syntax.Capture(renumber(n, ngroup, &ngroup))
+	}
+	re, err := yarex.Compile(syntax.Alternate(opts...).String())
+	if err != nil {
+		return nil, fmt.Errorf("(THIS SHOULD NOT HAPPEN) can't compile combined rules: %w", err)
+	}
+	if longest {
+		re.Longest()
+	}
+	return &Lexer{append([]Rule{}, rules...), re, groups}, nil
+}
+
+// renumber rebuilds n to be embedded in the combined pattern, where the groups
+// of n are numbered after the offset-th group. Names of groups are removed, since
+// they may conflict with those in other rules. ngroup counts up the groups.
+func renumber(n *syntax.Node, offset int, ngroup *int) *syntax.Node {
+	switch n.Op() {
+	case syntax.OpCapture:
+		*ngroup++
+		return syntax.Capture(renumber(n.Sub()[0], offset, ngroup))
+	case syntax.OpBackRef:
+		return syntax.BackRef(n.Index() + offset)
+	case syntax.OpRepeat:
+		return syntax.Repeat(renumber(n.Sub()[0], offset, ngroup), n.Min(), n.Max())
+	case syntax.OpConcat, syntax.OpAlternate:
+		subs := make([]*syntax.Node, len(n.Sub()))
+		for i, s := range n.Sub() {
+			subs[i] = renumber(s, offset, ngroup)
+		}
+		if n.Op() == syntax.OpConcat {
+			return syntax.Concat(subs...)
+		}
+		return syntax.Alternate(subs...)
+	}
+	return n // Leaves are immutable, so they can be shared.
+}
+
+// String returns the combined pattern of the rules.
+func (l *Lexer) String() string {
+	return l.re.String()
+}
+
+// Rules returns the rules of l.
+func (l *Lexer) Rules() []Rule {
+	return append([]Rule{}, l.rules...)
+}
+
+// Position is a position in the source text. Line and Column start at 1, and
+// Column counts characters rather than bytes.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Token is a token found by Scanner.
+type Token struct {
+	Type int
+	Text string
+	Pos  Position
+}
+
+// Scanner splits a source text into tokens. Like bufio.Scanner, call Scan to
+// advance to the next token, and Token to get it.
+type Scanner struct {
+	lexer *Lexer
+	src   string
+	pos   Position
+	tok   Token
+	err   error
+	match yarex.Match
+}
+
+// Scanner returns a Scanner to tokenize src.
+func (l *Lexer) Scanner(src string) *Scanner {
+	return &Scanner{lexer: l, src: src, pos: Position{0, 1, 1}}
+}
+
+// Scan advances s to the next token, which is available through Token. It
+// returns false when s reaches the end of the source or an error occurs. Err
+// reports the error, where no rule matches or a rule matches empty text.
+func (s *Scanner) Scan() bool {
+	if s.err != nil || s.pos.Offset == len(s.src) {
+		return false
+	}
+	l := s.lexer
+	if !l.re.FindStringMatchAt(s.src, s.pos.Offset, &s.match) {
+		s.err = fmt.Errorf("lex: %v: no rule matches", s.pos)
+		return false
+	}
+	loc := s.match.Index()
+	rule := 0
+	for i, g := range l.groups {
+		if loc[2*g] >= 0 {
+			rule = i
+			break
+		}
+	}
+	if loc[1] == loc[0] {
+		s.err = fmt.Errorf("lex: %v: rule %d (%q) matches empty text", s.pos, rule, l.rules[rule].Pattern)
+		return false
+	}
+	text := s.src[loc[0]:loc[1]]
+	s.tok = Token{l.rules[rule].Type, text, s.pos}
+	s.advance(text)
+	return true
+}
+
+// advance moves the position of s over text.
+func (s *Scanner) advance(text string) {
+	s.pos.Offset += len(text)
+	for i := 0; i < len(text); {
+		if text[i] == '\n' {
+			s.pos.Line++
+			s.pos.Column = 1
+			i++
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		s.pos.Column++
+		i += size
+	}
+}
+
+// Token returns the token found by the last call of Scan.
+func (s *Scanner) Token() Token {
+	return s.tok
+}
+
+// Pos returns the position where the next token starts.
+func (s *Scanner) Pos() Position {
+	return s.pos
+}
+
+// Err returns the error that stopped Scan, or nil if s reached the end of the
+// source successfully.
+func (s *Scanner) Err() error {
+	return s.err
+}
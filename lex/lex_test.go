@@ -0,0 +1,159 @@
+package lex_test
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/Maki-Daisuke/go-yarex/lex"
+)
+
+const (
+	Ident = iota
+	Keyword
+	Number
+	String
+	Space
+	Punct
+)
+
+var rules = []lex.Rule{
+	{`if|else`, Keyword},
+	{`[A-Za-z_]\w*`, Ident},
+	{`[0-9]+(?:\.[0-9]+)?`, Number},
+	{`(?P<q>["'])(?:\\.|[^\\"'\x0a])*\1`, String},
+	{`\s+`, Space},
+	{`[-+*/=(){};]|==`, Punct},
+}
+
+type tok struct {
+	typ  int
+	text string
+	pos  string
+}
+
+func scanAll(t *testing.T, l *lex.Lexer, src string) (toks []tok, err error) {
+	s := l.Scanner(src)
+	for s.Scan() {
+		tk := s.Token()
+		if src[tk.Pos.Offset:tk.Pos.Offset+len(tk.Text)] != tk.Text {
+			t.Errorf("offset %d of %q is inconsistent with the text of the token", tk.Pos.Offset, tk.Text)
+		}
+		if tk.Type != Space {
+			toks = append(toks, tok{tk.Type, tk.Text, tk.Pos.String()})
+		}
+	}
+	return toks, s.Err()
+}
+
+func TestScanner(t *testing.T) {
+	src := "if (x1 == 3.14) {\n\tiffy = \"a\\\"b\"; else y = 'c'\n}"
+	toks, err := scanAll(t, lex.MustCompileLongest(rules...), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []tok{
+		{Keyword, "if", "1:1"}, {Punct, "(", "1:4"}, {Ident, "x1", "1:5"}, {Punct, "==", "1:8"},
+		{Number, "3.14", "1:11"}, {Punct, ")", "1:15"}, {Punct, "{", "1:17"},
+		{Ident, "iffy", "2:2"}, {Punct, "=", "2:7"}, {String, `"a\"b"`, "2:9"}, {Punct, ";", "2:15"},
+		{Keyword, "else", "2:17"}, {Ident, "y", "2:22"}, {Punct, "=", "2:24"}, {String, "'c'", "2:26"},
+		{Punct, "}", "3:1"},
+	}
+	if !reflect.DeepEqual(toks, want) {
+		t.Errorf("want %v, but got %v", want, toks)
+	}
+}
+
+func TestScannerFirst(t *testing.T) {
+	// The first matching rule wins even if a later one matches longer.
+	toks, err := scanAll(t, lex.MustCompile(rules...), "iffy==1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []tok{{Keyword, "if", "1:1"}, {Ident, "fy", "1:3"}, {Punct, "=", "1:5"}, {Punct, "=", "1:6"}, {Number, "1", "1:7"}}
+	if !reflect.DeepEqual(toks, want) {
+		t.Errorf("want %v, but got %v", want, toks)
+	}
+}
+
+func TestScannerColumn(t *testing.T) {
+	toks, err := scanAll(t, lex.MustCompileLongest(rules...), "'αβ' x\n  'γ'\ny")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []tok{{String, "'αβ'", "1:1"}, {Ident, "x", "1:6"}, {String, "'γ'", "2:3"}, {Ident, "y", "3:1"}}
+	if !reflect.DeepEqual(toks, want) {
+		t.Errorf("want %v, but got %v", want, toks)
+	}
+}
+
+func TestScannerError(t *testing.T) {
+	l := lex.MustCompileLongest(rules...)
+	s := l.Scanner("x = 1\n  @")
+	for s.Scan() {
+	}
+	if err := s.Err(); err == nil || err.Error() != "lex: 2:3: no rule matches" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if p := s.Pos(); p.Offset != 8 {
+		t.Errorf("scanner should stop at 8, but stopped at %d", p.Offset)
+	}
+
+	l = lex.MustCompile(lex.Rule{`a`, 0}, lex.Rule{`b*`, 1})
+	s = l.Scanner("abc")
+	for s.Scan() {
+	}
+	if s.Err() == nil {
+		t.Errorf("scanner should fail for empty token, but did not")
+	}
+
+	if _, err := lex.Compile(lex.Rule{`a`, 0}, lex.Rule{`(b`, 1}); err == nil {
+		t.Errorf("Compile should fail for invalid pattern, but did not")
+	}
+}
+
+// TestScannerRules compares the scanner with trying each rule by regexp in turn.
+func TestScannerRules(t *testing.T) {
+	stds := make([]*regexp.Regexp, len(rules))
+	for i, r := range rules {
+		if r.Type == String {
+			r.Pattern = `"(?:\\.|[^\\"\x0a])*"|'(?:\\.|[^\\'\x0a])*'` // regexp lacks back-references.
+		}
+		stds[i] = regexp.MustCompile(`\A(?:` + r.Pattern + `)`)
+		stds[i].Longest()
+	}
+	src := "else1 elsif 12.5 3 x==y 'q\\'s' \"\" if(a)b;"
+	s := lex.MustCompileLongest(rules...).Scanner(src)
+	for p := 0; p < len(src); {
+		best, typ := -1, 0
+		for i, re := range stds {
+			if loc := re.FindStringIndex(src[p:]); loc != nil && loc[1] > best {
+				best, typ = loc[1], rules[i].Type
+			}
+		}
+		if !s.Scan() {
+			t.Fatalf("scanner stopped at %d: %v", p, s.Err())
+		}
+		if tk := s.Token(); tk.Type != typ || tk.Text != src[p:p+best] {
+			t.Errorf("want (%d, %q) at %d, but got (%d, %q)", typ, src[p:p+best], p, tk.Type, tk.Text)
+		}
+		p += best
+	}
+	if s.Scan() || s.Err() != nil {
+		t.Errorf("scanner should stop at the end without error")
+	}
+}
+
+func TestLexerString(t *testing.T) {
+	l := lex.MustCompile(lex.Rule{`(?P<x>a)\1`, 0}, lex.Rule{`(b)(c)\2`, 1})
+	if want := `((a)(?:\2))|((b)(c)(?:\5))`; l.String() != want {
+		t.Errorf("want %q, but got %q", want, l.String())
+	}
+	toks, err := scanAll(t, l, "aabcc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []tok{{0, "aa", "1:1"}, {1, "bcc", "1:3"}}; !reflect.DeepEqual(toks, want) {
+		t.Errorf("want %v, but got %v", want, toks)
+	}
+}
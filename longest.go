@@ -0,0 +1,111 @@
+package yarex
+
+// Leftmost-longest (POSIX) matching is implemented on top of the backtracking
+// matchers. In this mode, MatchContext.Success returns false, so that matchers
+// keep backtracking to explore all the alternatives, while the longest match
+// found so far is recorded. As regexp does, when there are multiple longest
+// matches with different submatches, the one found first by backtracking is
+// chosen.
+//
+// One-pass matchers need nothing special, since there is only one way to match
+// each prefix of string and they always choose to continue the match.
+
+// Success is called by matchers when the whole pattern matches at p. It calls
+// onSuccess and returns whether the matcher should stop. This is called by
+// compiled matchers. Do not use this for any other purposes.
+func (c MatchContext) Success(p int, onSuccess func(MatchContext)) bool {
+	onSuccess(c.Push(ContextKey{'c', 0}, p))
+	// In leftmost-longest mode, nothing can be longer than the rest of string.
+	return !c.longest || p == len(c.Input())
+}
+
+// Longest makes re prefer leftmost-longest matches to leftmost-first ones.
+// That is, it returns the longest match among those starting at the leftmost
+// position, as Longest of regexp. Note that this makes matching slower, since
+// all the alternatives need to be tried.
+func (re *Regexp) Longest() {
+	re.exe = longestExecer(re.exe)
+}
+
+// CompilePOSIX is like Compile, but the returned Regexp prefers
+// leftmost-longest matches as POSIX. Unlike regexp.CompilePOSIX, the syntax is
+// not restricted to POSIX ERE.
+func CompilePOSIX(ptn string) (*Regexp, error) {
+	re, err := Compile(ptn)
+	if err != nil {
+		return nil, err
+	}
+	re.Longest()
+	return re, nil
+}
+
+func MustCompilePOSIX(ptn string) *Regexp {
+	r, err := CompilePOSIX(ptn)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// longestExecer returns an execer for leftmost-longest matching equivalent to exe.
+func longestExecer(exe execer) execer {
+	switch e := exe.(type) {
+	case *closureExecer:
+		x := *e
+		x.longest = true
+		return &x
+	case *compiledExecer:
+		x := *e
+		x.longest = true
+		return &x
+	case opExecer:
+		e.longest = true
+		return e
+	case *bitParallelExecer:
+		x := *e
+		x.fallback = longestExecer(e.fallback)
+		return &x
+	}
+	return exe // One-pass matchers are leftmost-longest by nature.
+}
+
+// execLongest scans str from pos for the leftmost-longest match. run tries to
+// match at p, and is called for each starting position. If anchored is true,
+// only the match starting at pos is tried.
+func execLongest(str string, pos int, headOnly, anchored bool, minReq int, next func(string, int) int,
+	run func(ctx MatchContext, p int, onSuccess func(MatchContext)) bool, onSuccess func(MatchContext)) bool {
+	if headOnly && pos != 0 {
+		return false
+	}
+	sp := opStackPool.Get().(*[]opStackFrame)
+	stack := *sp
+	defer func() { *sp = stack; opStackPool.Put(sp) }()
+	getter := func() []opStackFrame { return stack }
+	setter := func(s []opStackFrame) { stack = s }
+	ctx0 := makeOpMatchContext(&str, &getter, &setter)
+	ctx0.longest = true
+	var best []opStackFrame
+	bestEnd := -1
+	record := func(c MatchContext) {
+		// The stack is overwritten by backtracking, so copy the frames.
+		if end := stack[c.stackTop-1].Pos; end > bestEnd {
+			bestEnd = end
+			best = append(best[:0], stack[:c.stackTop]...)
+		}
+	}
+	for i := pos; minReq <= len(str)-i; i = next(str, i) {
+		run(ctx0.Push(ContextKey{'c', 0}, i), i, record)
+		if bestEnd >= 0 {
+			bestGetter := func() []opStackFrame { return best }
+			bestSetter := func(s []opStackFrame) { best = s }
+			c := makeOpMatchContext(&str, &bestGetter, &bestSetter)
+			c.stackTop = len(best)
+			onSuccess(c)
+			return true
+		}
+		if headOnly || anchored {
+			break
+		}
+	}
+	return false
+}
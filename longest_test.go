@@ -0,0 +1,72 @@
+package yarex_test
+
+//go:generate cmd/yarexgen/yarexgen longest_test.go
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/Maki-Daisuke/go-yarex"
+)
+
+func testLongest(t *testing.T, ptn string, tests []string) {
+	std := regexp.MustCompile(ptn)
+	std.Longest()
+	res := map[string]*yarex.Regexp{
+		"OpTree":   yarex.MustCompileOp(ptn),
+		"Closure":  yarex.MustCompileClosure(ptn),
+		"Compiled": yarex.MustCompile(ptn),
+	}
+	if !yarex.IsCompiledMatcher(res["Compiled"]) {
+		t.Errorf("%v should be Compiled matcher, but isn't", res["Compiled"])
+	}
+	for _, re := range res {
+		re.Longest()
+	}
+	res["POSIX"] = yarex.MustCompilePOSIX(ptn)
+	for name, re := range res {
+		for _, str := range tests {
+			if loc, want := re.FindStringIndex(str), std.FindStringIndex(str); !reflect.DeepEqual(loc, want) {
+				t.Errorf("(%s) %v.FindStringIndex(%q) returned %v, but expected %v", name, re, str, loc, want)
+			}
+			if loc, want := yarex.FindStringSubmatchIndex(re, str), std.FindStringSubmatchIndex(str); !reflect.DeepEqual(loc, want) {
+				t.Errorf("(%s) %v: submatches of %q are %v, but expected %v", name, re, str, loc, want)
+			}
+		}
+	}
+}
+
+func TestLongest(t *testing.T) {
+	re := "a|ab|abc" //yarexgen
+	testLongest(t, re, []string{"", "a", "ab", "abc", "xabcd", "aab"})
+
+	re = "(a|ab)(c|bcd)" //yarexgen
+	testLongest(t, re, []string{"abcd", "abc", "ac", "xxabcdabc"})
+
+	re = "x*|xx+y" //yarexgen
+	testLongest(t, re, []string{"", "x", "xxxy", "xxx", "axxy"})
+
+	re = "(?:a|aa)*b?" //yarexgen
+	testLongest(t, re, []string{"aaab", "aaaa", "b", ""})
+
+	re = "(a+)(b+)?c|a" //yarexgen
+	testLongest(t, re, []string{"aaabbc", "aaabb", "aac", "a"})
+
+	re = "^(foo|foobar)$" //yarexgen
+	testLongest(t, re, []string{"foo", "foobar", "foob"})
+
+	re = "a(bc)?" //yarexgen
+	testLongest(t, re, []string{"a", "ab", "abc", "xabcbc"})
+}
+
+func TestLongestIsNotShared(t *testing.T) {
+	re := yarex.MustCompile("a|ab|abc")
+	re.Longest()
+	if s := yarex.MustCompile("a|ab|abc").FindString("abc"); s != "a" {
+		t.Errorf("Longest() should not affect other Regexps, but FindString returned %q", s)
+	}
+	if s := re.FindString("abc"); s != "abc" {
+		t.Errorf("want %q, but got %q", "abc", s)
+	}
+}
@@ -0,0 +1,85 @@
+package yarex
+
+// Match is a reusable buffer to receive the location of a match and its
+// submatches. Once a Match has grown enough, finding matches into it does not
+// allocate memory on heap, except in leftmost-longest mode. Reuse a Match for
+// many matches to avoid producing garbage. A Match must not be used by multiple
+// goroutines at once.
+type Match struct {
+	str    string
+	loc    []int // loc[2*i:2*i+2] is the location of the i-th group.
+	nsub   int
+	record func(MatchContext) // Reused not to allocate a closure for each match.
+}
+
+// reset prepares m for a match of a Regexp with nsub groups against str.
+func (m *Match) reset(str string, nsub int) {
+	m.str = str
+	m.loc = m.loc[:0]
+	m.nsub = nsub
+	if m.record == nil {
+		m.record = func(c MatchContext) {
+			m.loc = m.loc[:0]
+			for i := 0; i <= m.nsub; i++ {
+				start, end := c.capturedIndex(ContextKey{'c', uint(i)})
+				m.loc = append(m.loc, start, end)
+			}
+		}
+	}
+}
+
+// FindStringMatch finds the leftmost match of re in s and stores it into m.
+// It reports whether a match is found.
+func (re Regexp) FindStringMatch(s string, m *Match) bool {
+	m.reset(s, re.nsub)
+	if !re.exe.exec(s, 0, false, m.record) {
+		m.loc = m.loc[:0]
+		return false
+	}
+	return true
+}
+
+// FindStringMatchAt is like FindStringMatch, but only finds the match starting
+// exactly at pos as FindStringIndexAt.
+func (re Regexp) FindStringMatchAt(s string, pos int, m *Match) bool {
+	m.reset(s, re.nsub)
+	if pos < 0 || len(s) < pos || !re.exe.exec(s, pos, true, m.record) {
+		m.loc = m.loc[:0]
+		return false
+	}
+	return true
+}
+
+// Index returns the locations of the match and its submatches in the same
+// form as FindStringSubmatchIndex of regexp, or nil if the last match failed.
+// The returned slice is overwritten by the next match into m.
+func (m *Match) Index() []int {
+	if len(m.loc) == 0 {
+		return nil
+	}
+	return m.loc
+}
+
+// Group returns the text of the i-th group, where the 0th group is the entire
+// match. It returns empty string if the group is unmatched or out of range.
+func (m *Match) Group(i int) string {
+	if start, end := m.GroupIndex(i); start >= 0 {
+		return m.str[start:end]
+	}
+	return ""
+}
+
+// GroupIndex returns the location of the i-th group, or (-1, -1) if the group
+// is unmatched or out of range.
+func (m *Match) GroupIndex(i int) (start, end int) {
+	if i < 0 || len(m.loc) <= 2*i {
+		return -1, -1
+	}
+	return m.loc[2*i], m.loc[2*i+1]
+}
+
+// NumGroup returns the number of groups in the last match including the 0th
+// group, or 0 if the last match failed.
+func (m *Match) NumGroup() int {
+	return len(m.loc) / 2
+}
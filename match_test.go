@@ -3,6 +3,7 @@ package yarex_test
 //go:generate cmd/yarexgen/yarexgen match_test.go
 
 import (
+	"reflect"
 	"regexp"
 	"testing"
 
@@ -10,13 +11,20 @@ import (
 )
 
 func testMatchStrings(t *testing.T, restr string, tests []string) {
+	testMatchStringsAs(t, restr, restr, tests)
+}
+
+// testMatchStringsAs tests restr comparing with stdRestr in regexp syntax, for
+// patterns using syntax which regexp does not support.
+func testMatchStringsAs(t *testing.T, restr, stdRestr string, tests []string) {
 	ast, err := yarex.Parse(restr)
 	if err != nil {
 		t.Fatalf("want nil, but got %s", err)
 	}
-	stdRe := regexp.MustCompile(restr)
+	stdRe := regexp.MustCompile(stdRestr)
 	ast = yarex.OptimizeAst(ast)
 	opRe := yarex.MustCompileOp(restr)
+	cloRe := yarex.MustCompileClosure(restr)
 	compRe := yarex.MustCompile(restr)
 	if !yarex.IsCompiledMatcher(compRe) {
 		t.Errorf("%v should be Compiled matcher, but isn't", compRe)
@@ -37,6 +45,13 @@ func testMatchStrings(t *testing.T, restr string, tests []string) {
 				t.Errorf("(OpTree) %v shouldn't match against %q, but did", opRe, str)
 			}
 		}
+		if cloRe.MatchString(str) != match {
+			if match {
+				t.Errorf("(Closure) %v should match against %q, but didn't", cloRe, str)
+			} else {
+				t.Errorf("(Closure) %v shouldn't match against %q, but did", cloRe, str)
+			}
+		}
 		if compRe.MatchString(str) != match {
 			if match {
 				t.Errorf("(Compiled) %v should match against %q, but didn't", compRe, str)
@@ -125,6 +140,100 @@ func TestMatchQuantifier(t *testing.T) {
 	})
 }
 
+// TestMatchEmptyRepeat tests repeats of an empty pattern, which must not loop
+// forever.
+func TestMatchEmptyRepeat(t *testing.T) {
+	re := "f(?:)*o(?:){2}h" //yarexgen
+	testMatchStrings(t, re, []string{
+		"foh",
+		"fooh",
+		"fh",
+		"",
+	})
+}
+
+// TestMatchLargeQuantifier tests repeats too large to be unrolled, which are
+// compiled into loops counting the repeats.
+func TestMatchLargeQuantifier(t *testing.T) {
+	re := "(?:ab|cd){2,7}x" //yarexgen
+	testMatchStrings(t, re, []string{
+		"abx",
+		"abcdx",
+		"cdcdcdcdcdcdcdx",
+		"ababababababababx",
+		"abcdabx",
+		"abcabx",
+		"",
+	})
+	re = "^(?:a|bc){5,}$" //yarexgen
+	testMatchStrings(t, re, []string{
+		"aaaa",
+		"aaaaa",
+		"abcabca",
+		"bcbcbcbc",
+		"abcabcabcabcaaaaa",
+		"aaaaab",
+	})
+	re = "(?:a*b?){6}c" //yarexgen
+	testMatchStrings(t, re, []string{
+		"c",
+		"bbbbbbc",
+		"bbbbbbbc",
+		"aabbabbaabc",
+		"bbbbbbb",
+	})
+	re = "^(?:x|y(?:ab|ba){1,10}){3}$" //yarexgen
+	testMatchStrings(t, re, []string{
+		"xxx",
+		"yabxyba",
+		"xyabababababababababab",
+		"xyababababababababababab",
+		"yyy",
+	})
+
+	re = "(ab|c){3,8}(d)" //yarexgen
+	std := regexp.MustCompile(re)
+	var m yarex.Match
+	for _, yre := range []*yarex.Regexp{yarex.MustCompileClosure(re), yarex.MustCompile(re)} {
+		for _, str := range []string{"abccd", "ababd", "cccccccccd", "abcabcabcd", "d"} {
+			want := std.FindStringSubmatchIndex(str)
+			if ok := yre.FindStringMatch(str, &m); ok != (want != nil) || !reflect.DeepEqual(m.Index(), want) {
+				t.Errorf("%v.FindStringMatch(%q) returned %t with %v, but expected %v", yre, str, ok, m.Index(), want)
+			}
+		}
+	}
+}
+
+// TestMatchSharedTail tests patterns with a common tail, which is generated as
+// a sub-matcher called by all of them.
+func TestMatchSharedTail(t *testing.T) {
+	re := `^(?:a|b)+[x-z]*\d{3}-\d{4}$` //yarexgen
+	testMatchStrings(t, re, []string{
+		"a123-4567",
+		"abyz123-4567",
+		"123-4567",
+		"ab123-456",
+	})
+
+	// astMatch can't match empty bodies of infinite repeats, so compare with
+	// regexp directly.
+	res := []string{
+		`(?:a?)*(?:y?)*\d{3}-\d{4}`, //yarexgen
+		`(?:b?)*(?:y?)*\d{3}-\d{4}`, //yarexgen
+		`(?:[a-z]+)+\d{3}-\d{4}`,    //yarexgen
+	}
+	for _, re := range res {
+		std := regexp.MustCompile(re)
+		for _, yre := range []*yarex.Regexp{yarex.MustCompileClosure(re), yarex.MustCompile(re)} {
+			for _, str := range []string{"123-4567", "aayy123-4567", "bbyy123-4567", "ab123-4567", "yyy12-34567", ""} {
+				if got, want := yre.MatchString(str), std.MatchString(str); got != want {
+					t.Errorf("%v.MatchString(%q) returned %t, but expected %t", yre, str, got, want)
+				}
+			}
+		}
+	}
+}
+
 func TestMatchOpt(t *testing.T) {
 	re := "fo?oh" //yarexgen
 	testMatchStrings(t, re, []string{
@@ -169,6 +278,22 @@ func TestMatchWildcard(t *testing.T) {
 		"xx\nx",
 		"xxxxxa",
 	})
+
+	// Generated code must compile with several dots in a state, and with dots
+	// next to char classes.
+	wildcardTests := []string{"", "ab", "abc", "a\nbxc", "aXbYc", "日本語", "x\xffb\xfe", "\n\n", "ab.[c]"}
+	re = ".." //yarexgen
+	testMatchStrings(t, re, wildcardTests)
+	re = "a.b.c" //yarexgen
+	testMatchStrings(t, re, wildcardTests)
+	re = "x.{2}" //yarexgen
+	testMatchStrings(t, re, wildcardTests)
+	re = ".[ab]" //yarexgen
+	testMatchStrings(t, re, wildcardTests)
+	re = "[ab].[c-e]." //yarexgen
+	testMatchStrings(t, re, wildcardTests)
+	re = "(?:.[ab]){2,6}" //yarexgen
+	testMatchStrings(t, re, wildcardTests)
 }
 
 func TestMatchBegin(t *testing.T) {
@@ -215,6 +340,7 @@ func TestMatchBackRef(t *testing.T) {
 	}
 	ast = yarex.OptimizeAst(ast)
 	opRe := yarex.MustCompileOp(pattern)
+	cloRe := yarex.MustCompileClosure(pattern)
 	compRe := yarex.MustCompileOp(pattern)
 	for _, test := range tests {
 		if yarex.AstMatch(ast, test.str) != test.result {
@@ -231,6 +357,13 @@ func TestMatchBackRef(t *testing.T) {
 				t.Errorf("(OpTree) %v shouldn't match against %q, but did", opRe, test.str)
 			}
 		}
+		if cloRe.MatchString(test.str) != test.result {
+			if test.result {
+				t.Errorf("(Closure) %v should match against %q, but didn't", cloRe, test.str)
+			} else {
+				t.Errorf("(Closure) %v shouldn't match against %q, but did", cloRe, test.str)
+			}
+		}
 		if compRe.MatchString(test.str) != test.result {
 			if test.result {
 				t.Errorf("(Compiled) %v should match against %q, but didn't", compRe, test.str)
@@ -265,6 +398,35 @@ func TestMatchClass(t *testing.T) {
 	})
 }
 
+func TestMatchClassUnicode(t *testing.T) {
+	tests := []string{
+		"αβγ",
+		"αβγx",
+		"x",
+		"0x",
+		"ΑΒΓ",
+		"ΑβΓx",
+		"日本x",
+		"a𐀁x",
+		"𐀀𐃿",
+		"",
+	}
+	re := "[α-ω0-9]+x" //yarexgen
+	testMatchStrings(t, re, tests)
+	re = "^[α-ω0-9]{2}" //yarexgen
+	testMatchStrings(t, re, tests)
+	re = "[^α-ω]x" //yarexgen
+	testMatchStrings(t, re, tests)
+	re = "(?i)[α-ω]+x" //yarexgen
+	testMatchStrings(t, re, tests)
+	re = "(?i)^[^α-ω]" //yarexgen
+	testMatchStrings(t, re, tests)
+	re = "[\U00010000-\U000100ffa]+" //yarexgen
+	testMatchStrings(t, re, tests)
+	re = "[^\U00010000-\U000100ff]x" //yarexgen
+	testMatchStrings(t, re, tests)
+}
+
 func TestSipAddress(t *testing.T) {
 	re := `^["]{0,1}([^"]*)["]{0,1}[ ]*<(sip|tel|sips):(([^@]*)@){0,1}([^>^:]*|\[[a-fA-F0-9:]*\]):{0,1}([0-9]*){0,1}>(;.*){0,1}$` //yarexgen
 	testMatchStrings(t, re, []string{
@@ -275,3 +437,107 @@ func TestSipAddress(t *testing.T) {
 		"\"0333334444\"<sip:[2001:30:fe::4:123]>;user=phone",
 	})
 }
+
+func TestMatchFold(t *testing.T) {
+	tests := []string{
+		"hello",
+		"HeLLo world",
+		"hell",
+		"ASK",
+		"aſK", // LATIN SMALL LETTER LONG S and KELVIN SIGN
+		"asK!",
+		"xABc",
+		"xabC",
+		"XABC",
+		"ΣσςΣ",
+		"aB",
+		"Ab",
+		"cBAx",
+		"",
+	}
+	re := "(?i)hello" //yarexgen
+	testMatchStrings(t, re, tests)
+	testAPIs(t, re, tests)
+	re = "(?i)ask" //yarexgen
+	testMatchStrings(t, re, tests)
+	testAPIs(t, re, tests)
+	re = "(?i)σ+" //yarexgen
+	testMatchStrings(t, re, tests)
+	testAPIs(t, re, tests)
+	re = "x(?i:ab)c" //yarexgen
+	testMatchStrings(t, re, tests)
+	testAPIs(t, re, tests)
+	re = "(?i)a(?-i)b" //yarexgen
+	testMatchStrings(t, re, tests)
+	testAPIs(t, re, tests)
+	re = "(?i)[a-c]+x" //yarexgen
+	testMatchStrings(t, re, tests)
+	testAPIs(t, re, tests)
+	re = "(?i)[^a-c]" //yarexgen
+	testMatchStrings(t, re, tests)
+	testAPIs(t, re, tests)
+	re = "^(?i:h|a)(?:el|s)" //yarexgen
+	testMatchStrings(t, re, tests)
+	testAPIs(t, re, tests)
+}
+
+func TestMatchClassSetOperation(t *testing.T) {
+	tests := []string{
+		"hello",
+		"aeiou",
+		"AEIOU xyz",
+		"a1b2c3",
+		"123",
+		"_",
+		"αβγ",
+		"",
+	}
+	for _, test := range []struct{ std, ptn string }{
+		{`[b-df-hj-np-tv-z]+`, `[a-z&&[^aeiou]]+`},    //yarexgen
+		{`[A-Z_a-z]+`, `[\w--\d]+`},                   //yarexgen
+		{`^[0-9A-Z]+$`, `^[\w--[a-z]&&[^_]]+$`},       //yarexgen
+		{`[^b-df-hj-np-tv-z]`, `[^a-z--aeiou]`},       //yarexgen
+		{`[^\x00-\x{10FFFF}]`, `[\d&&[a-z]]`},         //yarexgen
+		{`(?i)[b-df-hj]{2}`, `(?i)[a-j--[aeiou]]{2}`}, //yarexgen
+	} {
+		testMatchStringsAs(t, test.ptn, test.std, tests)
+	}
+
+	// "--" and "&&" followed by ']' are not operators, but a range or literals.
+	tests = []string{"+", ",", "-", "*", "a+b", "&", "x&&y", "abc", ""}
+	re := `[+--]` //yarexgen
+	testMatchStrings(t, re, tests)
+	re = `[*--]+` //yarexgen
+	testMatchStrings(t, re, tests)
+	re = `[&&]` //yarexgen
+	testMatchStrings(t, re, tests)
+	re = `[a-c+--]+` //yarexgen
+	testMatchStrings(t, re, tests)
+
+	for _, ptn := range []string{`[a--]`, `[&&a]`, `[^&&a]`, `[a&&&&b]`, `[a--&&b]`, `[a&&[b]--&&c]`} {
+		if _, err := yarex.Compile(ptn); err == nil {
+			t.Errorf("Compile(%q) should fail, but succeeded", ptn)
+		}
+	}
+}
+
+func TestMatchPerlClass(t *testing.T) {
+	tests := []string{
+		"abc",
+		"a1 b2",
+		"foo_bar",
+		"\t\n",
+		"１２３", // FULLWIDTH DIGITs are not \d
+		"",
+	}
+	re := `\d+` //yarexgen
+	testMatchStrings(t, re, tests)
+	re = `\w\s\w` //yarexgen
+	testMatchStrings(t, re, tests)
+	re = `^\D*$` //yarexgen
+	testMatchStrings(t, re, tests)
+	re = `[\W\d]+` //yarexgen
+	testMatchStrings(t, re, tests)
+	re = `(?i)[^\S ]` //yarexgen
+	testMatchStrings(t, re, tests)
+}
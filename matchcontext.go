@@ -1,7 +1,5 @@
 package yarex
 
-import "unsafe"
-
 const initialStackSize = 64
 
 type stackFrame struct {
@@ -9,23 +7,12 @@ type stackFrame struct {
 	pos   int
 }
 
-type matchContext struct {
-	str      uintptr // *string              // string being matched
-	getStack uintptr // *func() []stackFrame // Accessors to stack to record capturing positions.
-	setStack uintptr // *func([]stackFrame)  // We use uintptr to avoid leaking param.
-	stackTop int     // stack top
-}
-
-func makeContext(str *string, getter *func() []stackFrame, setter *func([]stackFrame)) matchContext {
-	return matchContext{uintptr(unsafe.Pointer(str)), uintptr(unsafe.Pointer(getter)), uintptr(unsafe.Pointer(setter)), 0}
-}
-
 func (c matchContext) push(i uint, p int) matchContext {
-	st := (*(*func() []stackFrame)(unsafe.Pointer(c.getStack)))() // == c.getStack()
+	st := c.stack()
 	sf := stackFrame{i, p}
 	if len(st) <= c.stackTop {
 		st = append(st, sf)
-		(*(*func([]stackFrame))(unsafe.Pointer(c.setStack)))(st) // == c.setStack(st)
+		c.updateStack(st)
 	} else {
 		st[c.stackTop] = sf
 	}
@@ -35,7 +22,7 @@ func (c matchContext) push(i uint, p int) matchContext {
 
 // GetOffset returns (-1, -1) when it cannot find specified index.
 func (c matchContext) GetOffset(idx uint) (start int, end int) {
-	st := (*(*func() []stackFrame)(unsafe.Pointer(c.getStack)))() // == c.getStack()
+	st := c.stack()
 	i := c.stackTop - 1
 	for ; ; i-- {
 		if i == 0 {
@@ -62,6 +49,5 @@ func (c matchContext) GetCaptured(i uint) (string, bool) {
 	if start < 0 {
 		return "", false
 	}
-	str := *(*string)(unsafe.Pointer(c.str))
-	return str[start:end], true
+	return c.input()[start:end], true
 }
@@ -0,0 +1,417 @@
+package yarex
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// runeSet is a conservative set of characters used to decide which way to go
+// at a choice point of a one-pass pattern. Non-ASCII characters are not
+// distinguished from each other, and thus nonASCII means the set *may*
+// contain some non-ASCII characters.
+type runeSet struct {
+	ascii    AsciiMaskClass
+	nonASCII bool
+	eof      bool // end of string
+	any      bool // anything, i.e. the match can finish here
+}
+
+func (s runeSet) union(t runeSet) runeSet {
+	return runeSet{
+		AsciiMaskClass{s.ascii.Hi | t.ascii.Hi, s.ascii.Lo | t.ascii.Lo},
+		s.nonASCII || t.nonASCII,
+		s.eof || t.eof,
+		s.any || t.any,
+	}
+}
+
+func (s runeSet) isEmpty() bool {
+	return s.ascii.Hi == 0 && s.ascii.Lo == 0 && !s.nonASCII && !s.eof && !s.any
+}
+
+func (s runeSet) disjoint(t runeSet) bool {
+	if s.isEmpty() || t.isEmpty() {
+		return true
+	}
+	if s.any || t.any {
+		return false
+	}
+	return s.ascii.Hi&t.ascii.Hi == 0 && s.ascii.Lo&t.ascii.Lo == 0 && !(s.nonASCII && t.nonASCII) && !(s.eof && t.eof)
+}
+
+// contains reports whether the character at p of str may be in the set.
+func (s runeSet) contains(str string, p int) bool {
+	if s.any {
+		return true
+	}
+	if p >= len(str) {
+		return s.eof
+	}
+	if c := str[p]; c < utf8.RuneSelf {
+		return s.ascii.Contains(rune(c))
+	}
+	return s.nonASCII
+}
+
+func runeSetOfRune(r rune) runeSet {
+	var s runeSet
+	switch {
+	case r < 64:
+		s.ascii.Lo = 1 << uint(r)
+	case r < utf8.RuneSelf:
+		s.ascii.Hi = 1 << uint(r-64)
+	default:
+		s.nonASCII = true
+	}
+	return s
+}
+
+func runeSetOfClass(c CharClass) runeSet {
+	var s runeSet
+	for r := rune(0); r < utf8.RuneSelf; r++ {
+		if c.Contains(r) {
+			s = s.union(runeSetOfRune(r))
+		}
+	}
+	switch x := c.(type) {
+	case AsciiMaskClass:
+	case *RangeTableClass:
+		s.nonASCII = len(x.R32) > 0 || (len(x.R16) > 0 && x.R16[len(x.R16)-1].Hi >= utf8.RuneSelf)
+	default:
+		s.nonASCII = true
+	}
+	return s
+}
+
+// firstSet returns characters which can appear at the head of what re matches.
+// AstAssertEnd is regarded as if it matched the end of string.
+func firstSet(re Ast) runeSet {
+	switch r := re.(type) {
+	case AstLit:
+		if len(r) == 0 {
+			return runeSet{}
+		}
+		c, _ := utf8.DecodeRuneInString(string(r))
+		return runeSetOfRune(c)
+	case AstFoldLit:
+		if len(r) == 0 {
+			return runeSet{}
+		}
+		c, _ := utf8.DecodeRuneInString(string(r))
+		s := runeSetOfRune(c)
+		for f := unicode.SimpleFold(c); f != c; f = unicode.SimpleFold(f) {
+			s = s.union(runeSetOfRune(f))
+		}
+		return s
+	case AstNotNewline:
+		return runeSet{ascii: AsciiMaskClass{^uint64(0), ^uint64(0) &^ (1 << '\n')}, nonASCII: true}
+	case AstCharClass:
+		return runeSetOfClass(r.CharClass)
+	case AstAssertEnd:
+		return runeSet{eof: true}
+	case *AstSeq:
+		var s runeSet
+		for _, x := range r.seq {
+			s = s.union(firstSet(x))
+			if !canMatchEmpty(x) {
+				break
+			}
+		}
+		return s
+	case *AstAlt:
+		var s runeSet
+		for _, x := range r.opts {
+			s = s.union(firstSet(x))
+		}
+		return s
+	case *AstRepeat:
+		return firstSet(r.re)
+	case *AstCap:
+		return firstSet(r.re)
+	}
+	return runeSet{}
+}
+
+// canMatchEmpty is similar to canMatchZeroWidth, but regards AstAssertEnd as
+// non-empty, since firstSet treats it as a pseudo character.
+func canMatchEmpty(re Ast) bool {
+	switch r := re.(type) {
+	case AstAssertEnd:
+		return false
+	case *AstSeq:
+		for _, x := range r.seq {
+			if !canMatchEmpty(x) {
+				return false
+			}
+		}
+		return true
+	case *AstAlt:
+		for _, x := range r.opts {
+			if canMatchEmpty(x) {
+				return true
+			}
+		}
+		return false
+	case *AstRepeat:
+		return r.min == 0 || canMatchEmpty(r.re)
+	case *AstCap:
+		return canMatchEmpty(r.re)
+	}
+	return canMatchZeroWidth(re)
+}
+
+// isOnePass reports whether re is anchored at the beginning and can be matched
+// without backtracking, i.e. every choice can be decided by the next character.
+func isOnePass(re Ast) bool {
+	return canOnlyMatchAtBegining(re) && onePassCheck(re, runeSet{any: true})
+}
+
+// onePassCheck checks whether re is one-pass when it is followed by follow.
+func onePassCheck(re Ast, follow runeSet) bool {
+	switch r := re.(type) {
+	case AstLit, AstFoldLit, AstNotNewline, AstCharClass, AstAssertBegin, AstAssertEnd:
+		return true
+	case *AstSeq:
+		for i := len(r.seq) - 1; i >= 0; i-- {
+			if !onePassCheck(r.seq[i], follow) {
+				return false
+			}
+			if canMatchEmpty(r.seq[i]) {
+				follow = firstSet(r.seq[i]).union(follow)
+			} else {
+				follow = firstSet(r.seq[i])
+			}
+		}
+		return true
+	case *AstAlt:
+		predicts := make([]runeSet, len(r.opts))
+		nullable := false
+		for i, x := range r.opts {
+			if !onePassCheck(x, follow) {
+				return false
+			}
+			predicts[i] = firstSet(x)
+			if canMatchEmpty(x) {
+				if nullable {
+					return false
+				}
+				nullable = true
+				predicts[i] = predicts[i].union(follow)
+			}
+			for j := 0; j < i; j++ {
+				if !predicts[i].disjoint(predicts[j]) {
+					return false
+				}
+			}
+		}
+		return true
+	case *AstRepeat:
+		if canMatchZeroWidth(r.re) {
+			return false
+		}
+		first := firstSet(r.re)
+		if r.max != r.min && !first.disjoint(follow) {
+			return false
+		}
+		return onePassCheck(r.re, first.union(follow))
+	case *AstCap:
+		return onePassCheck(r.re, follow)
+	}
+	return false // AstBackRef
+}
+
+// onePassNode is a node of one-pass program. It is one of AstLit, AstFoldLit, AstNotNewline,
+// AstCharClass, AstAssertBegin, AstAssertEnd, onePassSeq, *onePassAlt,
+// *onePassRepeat and *onePassCap.
+type onePassNode interface{}
+
+type onePassSeq []onePassNode
+
+// onePassAlt is an AstAlt annotated with sets of characters to choose an option.
+// dflt is the index of the option which matches empty string, or -1.
+type onePassAlt struct {
+	opts   []onePassNode
+	firsts []runeSet
+	dflt   int
+}
+
+// onePassRepeat is an AstRepeat annotated with a set of characters to decide
+// whether to repeat once more.
+type onePassRepeat struct {
+	re       onePassNode
+	first    runeSet
+	min, max int
+}
+
+type onePassCap struct {
+	index uint
+	re    onePassNode
+}
+
+// onePassProg is a one-pass pattern. Choice points are annotated in advance so
+// that no set is computed while matching.
+type onePassProg struct {
+	node   onePassNode
+	nCaps  int
+	minReq int
+}
+
+func newOnePassProg(re Ast) *onePassProg {
+	prog := &onePassProg{nil, 1, minRequiredLengthOfAst(re)}
+	prog.node = prog.compile(re)
+	return prog
+}
+
+func (prog *onePassProg) compile(re Ast) onePassNode {
+	switch r := re.(type) {
+	case *AstSeq:
+		seq := make(onePassSeq, len(r.seq))
+		for i, x := range r.seq {
+			seq[i] = prog.compile(x)
+		}
+		return seq
+	case *AstAlt:
+		alt := &onePassAlt{make([]onePassNode, len(r.opts)), make([]runeSet, len(r.opts)), -1}
+		for i, x := range r.opts {
+			alt.opts[i] = prog.compile(x)
+			alt.firsts[i] = firstSet(x)
+			if canMatchEmpty(x) {
+				alt.dflt = i
+			}
+		}
+		return alt
+	case *AstRepeat:
+		return &onePassRepeat{prog.compile(r.re), firstSet(r.re), r.min, r.max}
+	case *AstCap:
+		if int(r.index) >= prog.nCaps {
+			prog.nCaps = int(r.index) + 1
+		}
+		return &onePassCap{r.index, prog.compile(r.re)}
+	}
+	return re
+}
+
+type onePassExecer struct {
+	prog *onePassProg
+}
+
+func (oe onePassExecer) exec(str string, pos int, anchored bool, onSuccess func(MatchContext)) bool {
+	prog := oe.prog
+	if pos != 0 || len(str) < prog.minReq {
+		return false
+	}
+	var local [16]int
+	var caps []int
+	if 2*prog.nCaps <= len(local) {
+		caps = local[:2*prog.nCaps]
+	} else {
+		caps = make([]int, 2*prog.nCaps)
+	}
+	for i := range caps {
+		caps[i] = -1
+	}
+	p := onePassRun(prog.node, str, 0, caps)
+	if p < 0 {
+		return false
+	}
+	caps[0] = 0
+	caps[1] = p
+	OnePassSucceed(str, caps, onSuccess)
+	return true
+}
+
+// onePassRun matches node at p by a single forward scan, and returns the position
+// after matching, or -1 if fails.
+func onePassRun(node onePassNode, str string, p int, caps []int) int {
+	switch r := node.(type) {
+	case AstLit:
+		if len(str)-p < len(r) || str[p:p+len(r)] != string(r) {
+			return -1
+		}
+		return p + len(r)
+	case AstFoldLit:
+		return MatchFoldLit(str, p, string(r))
+	case AstNotNewline:
+		c, size := utf8.DecodeRuneInString(str[p:])
+		if size == 0 || c == '\n' {
+			return -1
+		}
+		return p + size
+	case AstCharClass:
+		c, size := utf8.DecodeRuneInString(str[p:])
+		if size == 0 || !r.Contains(c) {
+			return -1
+		}
+		return p + size
+	case AstAssertBegin:
+		if p != 0 {
+			return -1
+		}
+		return p
+	case AstAssertEnd:
+		if p != len(str) {
+			return -1
+		}
+		return p
+	case onePassSeq:
+		for _, x := range r {
+			if p = onePassRun(x, str, p, caps); p < 0 {
+				return -1
+			}
+		}
+		return p
+	case *onePassAlt:
+		for i, f := range r.firsts {
+			if f.contains(str, p) {
+				return onePassRun(r.opts[i], str, p, caps)
+			}
+		}
+		if r.dflt < 0 {
+			return -1
+		}
+		return onePassRun(r.opts[r.dflt], str, p, caps)
+	case *onePassRepeat:
+		n := 0
+		for ; n < r.min; n++ {
+			if p = onePassRun(r.re, str, p, caps); p < 0 {
+				return -1
+			}
+		}
+		for ; (r.max < 0 || n < r.max) && r.first.contains(str, p); n++ {
+			if p = onePassRun(r.re, str, p, caps); p < 0 {
+				return -1
+			}
+		}
+		return p
+	case *onePassCap:
+		start := p
+		if p = onePassRun(r.re, str, p, caps); p < 0 {
+			return -1
+		}
+		caps[2*r.index] = start
+		caps[2*r.index+1] = p
+		return p
+	}
+	panic("EXECUTION SHOULD NOT REACH HERE")
+}
+
+// OnePassSucceed is called by one-pass matchers, including compiled ones, to
+// pass captured positions to onSuccess. caps holds start and end positions of
+// each capture, where caps[0] and caps[1] are those of the whole match.
+// Do not use this for any other purposes.
+func OnePassSucceed(str string, caps []int, onSuccess func(MatchContext)) {
+	var local [32]opStackFrame
+	stack := local[:0]
+	stack = append(stack, opStackFrame{ContextKey{'c', 0}, caps[0]})
+	for i := 1; 2*i+1 < len(caps); i++ {
+		if caps[2*i] >= 0 {
+			stack = append(stack, opStackFrame{ContextKey{'c', uint(i)}, caps[2*i]}, opStackFrame{ContextKey{'c', uint(i)}, caps[2*i+1]})
+		}
+	}
+	stack = append(stack, opStackFrame{ContextKey{'c', 0}, caps[1]})
+	getter := func() []opStackFrame { return stack }
+	setter := func(s []opStackFrame) { stack = s }
+	ctx := makeOpMatchContext(&str, &getter, &setter)
+	ctx.stackTop = len(stack)
+	onSuccess(ctx)
+}
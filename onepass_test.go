@@ -0,0 +1,111 @@
+package yarex_test
+
+//go:generate cmd/yarexgen/yarexgen onepass_test.go
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/Maki-Daisuke/go-yarex"
+)
+
+func TestIsOnePass(t *testing.T) {
+	tests := []struct {
+		ptn    string
+		result bool
+	}{
+		{`^foo bar`, true},
+		{`^[A-Z]{3}-[0-9]{4}$`, true},
+		{`^(?:foo|bar)+$`, true},
+		{`^([a-z]+)@([a-z.]+)$`, true},
+		{`^(?:ab)*$`, true},
+		{`^(a|)b`, true},
+		{`foo`, false},
+		{`^a*`, false},
+		{`^a?a`, false},
+		{`^(?:foo|fo)oh`, false},
+		{`^[a-z]+[0-9a-z]$`, false},
+		{`^(hoge)\1fuga`, false},
+	}
+	for _, test := range tests {
+		if yarex.IsOnePass(test.ptn) != test.result {
+			t.Errorf("IsOnePass(%q) should be %t, but actually not", test.ptn, test.result)
+		}
+	}
+}
+
+func testOnePass(t *testing.T, restr string, tests []string) {
+	stdRe := regexp.MustCompile(restr)
+	opRe := yarex.MustCompileOnePass(restr)
+	compRe := yarex.MustCompile(restr)
+	if !yarex.IsCompiledMatcher(compRe) || !yarex.IsOnePassMatcher(compRe) {
+		t.Errorf("%v should be compiled one-pass matcher, but isn't", compRe)
+	}
+	for _, str := range tests {
+		if opRe.MatchString(str) != stdRe.MatchString(str) {
+			t.Errorf("(OnePass) %v.MatchString(%q) should be %t, but actually not", opRe, str, stdRe.MatchString(str))
+		}
+		if compRe.MatchString(str) != stdRe.MatchString(str) {
+			t.Errorf("(Compiled) %v.MatchString(%q) should be %t, but actually not", compRe, str, stdRe.MatchString(str))
+		}
+		loc := stdRe.FindStringIndex(str)
+		if !reflect.DeepEqual(opRe.FindStringIndex(str), loc) {
+			t.Errorf("(OnePass) %v.FindStringIndex(%q) returned %v, but expected %v", opRe, str, opRe.FindStringIndex(str), loc)
+		}
+		if !reflect.DeepEqual(compRe.FindStringIndex(str), loc) {
+			t.Errorf("(Compiled) %v.FindStringIndex(%q) returned %v, but expected %v", compRe, str, compRe.FindStringIndex(str), loc)
+		}
+	}
+}
+
+func TestOnePass(t *testing.T) {
+	re := `^[A-Z]{3}-[0-9]{4}$` //yarexgen
+	testOnePass(t, re, []string{
+		"ABC-1234",
+		"ABC-12345",
+		"AB-1234",
+		"abc-1234",
+		" ABC-1234",
+		"",
+	})
+
+	re = `^(?:foo|bar)+$` //yarexgen
+	testOnePass(t, re, []string{
+		"foo",
+		"foobarfoo",
+		"foob",
+		"fo",
+		"",
+		"barbar\n",
+	})
+
+	re = `^([a-z]+)@([a-z.]+)$` //yarexgen
+	testOnePass(t, re, []string{
+		"user@example.com",
+		"user@",
+		"@example.com",
+		"user@example.com.",
+		"user@@example.com",
+		"ユーザ@example.com",
+	})
+
+	re = `^(?:ab)*$` //yarexgen
+	testOnePass(t, re, []string{
+		"",
+		"ab",
+		"abab",
+		"aba",
+		"ac",
+	})
+
+	re = `^(a|)b.` //yarexgen
+	testOnePass(t, re, []string{
+		"abc",
+		"bc",
+		"b",
+		"ab\n",
+		"aabc",
+		"bあ",
+	})
+}
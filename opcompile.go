@@ -33,6 +33,15 @@ func (oc *opCompiler) compile(re Ast, follower OpTree) OpTree {
 			},
 			str: str,
 		}
+	case AstFoldLit:
+		str := string(r)
+		return &OpFoldStr{
+			OpBase: OpBase{
+				minReq:   follower.minimumReq() + minFoldLen(str),
+				follower: follower,
+			},
+			str: str,
+		}
 	case *AstSeq:
 		return oc.compileSeq(r.seq, follower)
 	case *AstAlt:
@@ -165,6 +174,8 @@ func canMatchZeroWidth(re Ast) bool {
 		return false
 	case AstLit:
 		return len(string(r)) == 0
+	case AstFoldLit:
+		return len(string(r)) == 0
 	case *AstSeq:
 		for _, s := range r.seq {
 			if !canMatchZeroWidth(s) {
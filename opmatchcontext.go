@@ -1,9 +1,6 @@
 package yarex
 
-import (
-	"sync"
-	"unsafe"
-)
+import "sync"
 
 type ContextKey struct {
 	Kind  rune
@@ -22,24 +19,13 @@ var opStackPool = sync.Pool{
 	},
 }
 
-type MatchContext struct {
-	Str      uintptr // *string                // string being matched
-	getStack uintptr // *func() []opStackFrame // Accessors to stack to record capturing positions.
-	setStack uintptr // *func([]opStackFrame)  // We use uintptr to avoid leaking param.
-	stackTop int     // stack top
-}
-
-func makeOpMatchContext(str *string, getter *func() []opStackFrame, setter *func([]opStackFrame)) MatchContext {
-	return MatchContext{uintptr(unsafe.Pointer(str)), uintptr(unsafe.Pointer(getter)), uintptr(unsafe.Pointer(setter)), 0}
-}
-
 func (c MatchContext) Push(k ContextKey, p int) MatchContext {
-	st := (*(*func() []opStackFrame)(unsafe.Pointer(c.getStack)))() // c.getStack()
+	st := c.stack()
 	sf := opStackFrame{k, p}
 	if len(st) <= c.stackTop {
 		st = append(st, sf)
 		st = st[:cap(st)]
-		(*(*func([]opStackFrame))(unsafe.Pointer(c.setStack)))(st) // c.setStack(st)
+		c.updateStack(st)
 	} else {
 		st[c.stackTop] = sf
 	}
@@ -52,16 +38,25 @@ func (c MatchContext) GetCaptured(k ContextKey) (string, bool) {
 	if loc == nil {
 		return "", false
 	}
-	return (*(*string)(unsafe.Pointer(c.Str)))[loc[0]:loc[1]], true
+	return c.Input()[loc[0]:loc[1]], true
 }
 
 func (c MatchContext) GetCapturedIndex(k ContextKey) []int {
-	var start, end int
-	st := (*(*func() []opStackFrame)(unsafe.Pointer(c.getStack)))() // c.getStack()
+	start, end := c.capturedIndex(k)
+	if start < 0 {
+		return nil
+	}
+	return []int{start, end}
+}
+
+// capturedIndex is like GetCapturedIndex, but returns (-1, -1) instead of nil
+// when k is not captured, so that it does not allocate memory.
+func (c MatchContext) capturedIndex(k ContextKey) (start, end int) {
+	st := c.stack()
 	i := c.stackTop - 1
 	for ; ; i-- {
 		if i == 0 {
-			return nil
+			return -1, -1
 		}
 		if st[i].Key == k {
 			end = st[i].Pos
@@ -72,7 +67,7 @@ func (c MatchContext) GetCapturedIndex(k ContextKey) []int {
 	for ; i >= 0; i-- {
 		if st[i].Key == k {
 			start = st[i].Pos
-			return []int{start, end}
+			return start, end
 		}
 	}
 	// This should not happen.
@@ -80,7 +75,7 @@ func (c MatchContext) GetCapturedIndex(k ContextKey) []int {
 }
 
 func (c MatchContext) FindVal(k ContextKey) int {
-	st := (*(*func() []opStackFrame)(unsafe.Pointer(c.getStack)))() // c.getStack()
+	st := c.stack()
 	for i := c.stackTop - 1; i >= 0; i-- {
 		if st[i].Key == k {
 			return st[i].Pos
@@ -41,7 +41,7 @@ func optimizeAstFlattenSeqAndAlt(re Ast) Ast {
 		out := *v
 		out.re = optimizeAstFlattenSeqAndAlt(v.re)
 		return &out
-	case AstLit, AstNotNewline, AstAssertBegin, AstAssertEnd, AstBackRef, AstCharClass:
+	case AstLit, AstFoldLit, AstNotNewline, AstAssertBegin, AstAssertEnd, AstBackRef, AstCharClass:
 		return v
 	default:
 		panic(fmt.Errorf("IMPLEMENT optimizeAstFlattenSeqAndAlt for %T", re))
@@ -55,8 +55,31 @@ func optimizeAstUnwrapSingletonSeqAndAlt(re Ast) Ast {
 	case *AstSeq:
 		out := make([]Ast, 0, len(v.seq))
 		var acc *string = nil
+		var foldAcc *string = nil
 		for _, r := range v.seq {
-			r = optimizeAstUnwrapSingletonSeqAndAlt(r)
+			if _, ok := r.(AstFoldLit); !ok { // AstFoldLit is joined before turned into AstLit
+				r = optimizeAstUnwrapSingletonSeqAndAlt(r)
+			}
+			if lit, ok := r.(AstLit); ok && len(lit) == 0 {
+				continue // Empty literal, e.g. (?i), is just ignored.
+			}
+			if lit, ok := r.(AstFoldLit); ok {
+				if acc != nil {
+					out = append(out, AstLit(*acc))
+					acc = nil
+				}
+				if foldAcc == nil {
+					s := string(lit)
+					foldAcc = &s
+				} else {
+					*foldAcc = *foldAcc + string(lit)
+				}
+				continue
+			}
+			if foldAcc != nil {
+				out = append(out, foldLit(*foldAcc))
+				foldAcc = nil
+			}
 			if lit, ok := r.(AstLit); ok {
 				if acc == nil {
 					s := string(lit)
@@ -75,6 +98,9 @@ func optimizeAstUnwrapSingletonSeqAndAlt(re Ast) Ast {
 		if acc != nil {
 			out = append(out, AstLit(*acc))
 		}
+		if foldAcc != nil {
+			out = append(out, foldLit(*foldAcc))
+		}
 		switch len(out) {
 		case 0:
 			return AstLit("")
@@ -97,16 +123,30 @@ func optimizeAstUnwrapSingletonSeqAndAlt(re Ast) Ast {
 	case *AstRepeat:
 		out := *v
 		out.re = optimizeAstUnwrapSingletonSeqAndAlt(v.re)
+		if lit, ok := out.re.(AstLit); ok && len(lit) == 0 {
+			return lit // Repeating an empty literal, e.g. (?:)*, never advances.
+		}
 		return &out
 	case *AstCap:
 		out := *v
 		out.re = optimizeAstUnwrapSingletonSeqAndAlt(v.re)
 		return &out
+	case AstFoldLit:
+		return foldLit(string(v))
 	default:
 		return v
 	}
 }
 
+// foldLit returns AstLit instead of AstFoldLit if no character in lit is
+// affected by case folding, e.g. digits and symbols.
+func foldLit(lit string) Ast {
+	if hasFold(lit) {
+		return AstFoldLit(lit)
+	}
+	return AstLit(lit)
+}
+
 func canOnlyMatchAtBegining(re Ast) bool {
 	switch v := re.(type) {
 	case AstAssertBegin:
@@ -146,6 +186,8 @@ func minRequiredLengthOfAst(re Ast) int {
 		return 1
 	case AstLit:
 		return len(string(v))
+	case AstFoldLit:
+		return minFoldLen(string(v))
 	case *AstSeq:
 		acc := 0
 		for _, r := range v.seq {
@@ -169,3 +211,17 @@ func minRequiredLengthOfAst(re Ast) int {
 		panic(fmt.Errorf("IMPLEMENT optimizeAstFlattenSeqAndAlt for %T", re))
 	}
 }
+
+// maxUnrolledRepeat is the maximum number of copies of the body of a repeat
+// that compilers unroll. Larger repeats are compiled into loops counting the
+// repeats, so that the size of compiled code does not grow with the bounds.
+const maxUnrolledRepeat = 4
+
+// isLargeRepeat reports whether unrolling a repeat {min,max} needs more copies
+// of its body than maxUnrolledRepeat.
+func isLargeRepeat(min, max int) bool {
+	if max < 0 {
+		return min+1 > maxUnrolledRepeat
+	}
+	return max > maxUnrolledRepeat
+}
@@ -24,6 +24,12 @@ type OpStr struct {
 	str string
 }
 
+// OpStr matched ignoring case
+type OpFoldStr struct {
+	OpBase
+	str string
+}
+
 type OpAlt struct {
 	OpBase
 	alt OpTree
@@ -3,35 +3,41 @@ package yarex
 import (
 	"strings"
 	"unicode/utf8"
-	"unsafe"
 )
 
 type opExecer struct {
-	op OpTree
+	op      OpTree
+	longest bool
 }
 
-func (oe opExecer) exec(str string, pos int, onSuccess func(MatchContext)) bool {
+func (oe opExecer) exec(str string, pos int, anchored bool, onSuccess func(MatchContext)) bool {
 	op := oe.op
 	_, headOnly := op.(*OpAssertBegin)
 	if headOnly && pos != 0 {
 		return false
 	}
 	minReq := op.minimumReq()
+	if oe.longest {
+		return execLongest(str, pos, headOnly, anchored, minReq, nextStart, func(ctx MatchContext, p int, k func(MatchContext)) bool {
+			return opTreeExec(op, ctx, p, k)
+		}, onSuccess)
+	}
 	if minReq > len(str)-pos {
 		return false
 	}
-	stack := *(opStackPool.Get().(*[]opStackFrame))
-	defer func() { opStackPool.Put(&stack) }()
+	sp := opStackPool.Get().(*[]opStackFrame)
+	stack := *sp
+	defer func() { *sp = stack; opStackPool.Put(sp) }()
 	getter := func() []opStackFrame { return stack }
 	setter := func(s []opStackFrame) { stack = s }
 	ctx0 := makeOpMatchContext(&str, &getter, &setter)
-	if opTreeExec(op, ctx0.Push(ContextKey{'c', 0}, 0), pos, onSuccess) {
+	if opTreeExec(op, ctx0.Push(ContextKey{'c', 0}, pos), pos, onSuccess) {
 		return true
 	}
-	if headOnly {
+	if headOnly || anchored {
 		return false
 	}
-	for i := pos + 1; minReq <= len(str)-i; i++ {
+	for i := nextStart(str, pos); minReq <= len(str)-i; i = nextStart(str, i) {
 		if opTreeExec(op, ctx0.Push(ContextKey{'c', 0}, i), i, onSuccess) {
 			return true
 		}
@@ -40,7 +46,7 @@ func (oe opExecer) exec(str string, pos int, onSuccess func(MatchContext)) bool
 }
 
 func opTreeExec(next OpTree, ctx MatchContext, p int, onSuccess func(MatchContext)) bool {
-	str := *(*string)(unsafe.Pointer(ctx.Str))
+	str := ctx.Input()
 	var (
 		localStack [16]int
 		heapStack  *[]int
@@ -48,9 +54,7 @@ func opTreeExec(next OpTree, ctx MatchContext, p int, onSuccess func(MatchContex
 	for {
 		switch op := next.(type) {
 		case OpSuccess:
-			ctx = ctx.Push(ContextKey{'c', 0}, p)
-			onSuccess(ctx)
-			return true
+			return ctx.Success(p, onSuccess)
 		case *OpStr:
 			if len(str)-p < op.minReq {
 				return false
@@ -62,6 +66,14 @@ func opTreeExec(next OpTree, ctx MatchContext, p int, onSuccess func(MatchContex
 			}
 			next = op.follower
 			p += len(op.str)
+		case *OpFoldStr:
+			if len(str)-p < op.minReq {
+				return false
+			}
+			if p = MatchFoldLit(str, p, op.str); p < 0 {
+				return false
+			}
+			next = op.follower
 		case *OpAlt:
 			if opTreeExec(op.follower, ctx, p, onSuccess) {
 				return true
@@ -132,11 +144,12 @@ func opTreeExec(next OpTree, ctx MatchContext, p int, onSuccess func(MatchContex
 			next = op.follower
 		case *OpRepeatClass:
 			endPos := len(str) - op.minReq - 1
+			var r rune
 			size := 0
 			n := 0
 			for (op.max < 0 || n < op.max) && p <= endPos {
-				r, size := utf8.DecodeRuneInString(str[p:])
-				if size == 0 || r == utf8.RuneError {
+				r, size = utf8.DecodeRuneInString(str[p:])
+				if size == 0 {
 					break
 				}
 				if !op.CharClass.Contains(r) {
@@ -165,8 +178,8 @@ func opTreeExec(next OpTree, ctx MatchContext, p int, onSuccess func(MatchContex
 			n++
 			p += size
 			for (op.max < 0 || n < op.max) && p <= endPos {
-				r, size := utf8.DecodeRuneInString(str[p:])
-				if size == 0 || r == utf8.RuneError {
+				r, size = utf8.DecodeRuneInString(str[p:])
+				if size == 0 {
 					break
 				}
 				if !op.CharClass.Contains(r) {
@@ -196,7 +209,7 @@ func opTreeExec(next OpTree, ctx MatchContext, p int, onSuccess func(MatchContex
 				return false
 			}
 			r, size := utf8.DecodeRuneInString(str[p:])
-			if size == 0 || r == utf8.RuneError {
+			if size == 0 {
 				return false
 			}
 			if !op.cls.Contains(r) {
@@ -209,7 +222,7 @@ func opTreeExec(next OpTree, ctx MatchContext, p int, onSuccess func(MatchContex
 				return false
 			}
 			r, size := utf8.DecodeRuneInString(str[p:])
-			if size == 0 || r == utf8.RuneError {
+			if size == 0 {
 				return false
 			}
 			if r == '\n' {
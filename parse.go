@@ -20,9 +20,18 @@ func parse(s string) (re Ast, err error) {
 	return re, nil
 }
 
+// Perl character classes, which contain only ASCII characters as in regexp.
+var perlClasses = map[rune]AsciiMaskClass{
+	'd': asciiMaskOfRanges([]runeRange{{'0', '9'}}),
+	's': asciiMaskOfRanges([]runeRange{{'\t', '\n'}, {'\f', '\r'}, {' ', ' '}}),
+	'w': asciiMaskOfRanges([]runeRange{{'0', '9'}, {'A', 'Z'}, {'_', '_'}, {'a', 'z'}}),
+}
+
 type parser struct {
 	openCaptures  uint
 	closeCaptures uint
+	fold          bool // case-insensitive, i.e. (?i) flag is set
+	names         map[string]bool
 }
 
 func (*parser) parseLit(str []rune) (Ast, []rune) {
@@ -57,11 +66,20 @@ LOOP:
 			re, str = p.parseClass(str)
 		case '(':
 			re, str = p.parseGroup(str)
+			if lit, ok := re.(AstLit); ok && len(lit) == 0 && len(str) > 0 {
+				switch str[0] {
+				case '*', '+', '?', '{': // A flag group like (?i) has nothing to repeat.
+					panic(fmt.Errorf("Missing argument to repetition operator: %q", string(str)))
+				}
+			}
 		case ')', '|':
 			break LOOP
 		default:
 			re, str = p.parseLit(str)
 		}
+		if lit, ok := re.(AstLit); ok && p.fold {
+			re = AstFoldLit(lit)
+		}
 		re, str = p.parseQuantifier(str, re)
 		seq = append(seq, re)
 	}
@@ -103,27 +121,96 @@ func (p *parser) parseGroup(str []rune) (Ast, []rune) {
 		panic(fmt.Errorf("Unmatched '(' : %q", string(str)))
 	}
 	if str[1] != '?' {
-		return p.parseCapture(str[1:])
+		return p.parseCapture(str[1:], "")
+	}
+	if rest := str[2:]; len(rest) > 0 && rest[0] == '<' || len(rest) > 1 && rest[0] == 'P' && rest[1] == '<' {
+		return p.parseNamedCapture(str)
 	}
-	if str[2] != ':' {
-		panic(fmt.Errorf("Unknown extended pattern syntax: %q", string(str)))
+	// Parse flags in (?flags) or (?flags:re), where flags is "i" or "-i".
+	fold := p.fold
+	negate := false
+	i := 2
+	for ; i < len(str) && str[i] != ':' && str[i] != ')'; i++ {
+		switch {
+		case str[i] == 'i':
+			fold = !negate
+		case str[i] == '-' && !negate:
+			negate = true
+		default:
+			panic(fmt.Errorf("Unknown extended pattern syntax: %q", string(str)))
+		}
+	}
+	if i == len(str) {
+		panic(fmt.Errorf("Unmatched '(' : %q", string(str)))
+	}
+	if str[i] == ')' {
+		if i == 2 || str[i-1] == '-' {
+			panic(fmt.Errorf("Unknown extended pattern syntax: %q", string(str)))
+		}
+		p.fold = fold // This lasts until the end of the enclosing group.
+		return AstLit(""), str[i+1:]
 	}
-	re, remain := p.parseAlt(str[3:])
-	if remain[0] != ')' {
+	saved := p.fold
+	p.fold = fold
+	re, remain := p.parseAlt(str[i+1:])
+	p.fold = saved
+	if len(remain) == 0 || remain[0] != ')' {
 		panic(fmt.Errorf("Unmatched '(' : %q", string(str)))
 	}
 	return re, remain[1:]
 }
 
-func (p *parser) parseCapture(str []rune) (Ast, []rune) {
+// parseNamedCapture parses (?P<name>re) or (?<name>re).
+func (p *parser) parseNamedCapture(str []rune) (Ast, []rune) {
+	i := 3
+	if str[2] == 'P' {
+		i = 4
+	}
+	start := i
+	for i < len(str) && str[i] != '>' {
+		i++
+	}
+	if i == len(str) {
+		panic(fmt.Errorf("Unmatched '<' in group name: %q", string(str)))
+	}
+	name := string(str[start:i])
+	if !isValidCaptureName(name) {
+		panic(fmt.Errorf("Invalid group name: %q", string(str[:i+1])))
+	}
+	if p.names[name] {
+		panic(fmt.Errorf("Duplicate group name: %q", string(str[:i+1])))
+	}
+	if p.names == nil {
+		p.names = map[string]bool{}
+	}
+	p.names[name] = true
+	return p.parseCapture(str[i+1:], name)
+}
+
+// isValidCaptureName reports whether name consists of word characters.
+func isValidCaptureName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !perlClasses['w'].Contains(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *parser) parseCapture(str []rune, name string) (Ast, []rune) {
 	p.openCaptures++
 	index := p.openCaptures
+	saved := p.fold
 	re, remain := p.parseAlt(str)
-	if remain[0] != ')' {
+	p.fold = saved
+	if len(remain) == 0 || remain[0] != ')' {
 		panic(fmt.Errorf("Unmatched '(' : %q", string(str)))
 	}
 	p.closeCaptures++
-	return &AstCap{index, re}, remain[1:]
+	return &AstCap{index, re, name}, remain[1:]
 }
 
 func (p *parser) parseQuantifier(str []rune, re Ast) (Ast, []rune) {
@@ -139,15 +226,18 @@ func (p *parser) parseQuantifier(str []rune, re Ast) (Ast, []rune) {
 		return &AstRepeat{re, 0, 1}, str[1:]
 	case '{':
 		start, remain := p.parseInt(str[1:])
-		if remain == nil {
+		if len(remain) == 0 {
 			panic(fmt.Errorf(`Invalid quantifier: %q`, string(str)))
 		}
 		switch remain[0] {
 		case '}':
 			return &AstRepeat{re, start, start}, remain[1:]
 		case ',':
+			if len(remain) > 1 && remain[1] == '}' { // {n,}
+				return &AstRepeat{re, start, -1}, remain[2:]
+			}
 			end, remain := p.parseInt(remain[1:])
-			if remain == nil {
+			if len(remain) == 0 {
 				panic(fmt.Errorf(`Invalid quantifier: %q`, string(str)))
 			}
 			if remain[0] != '}' {
@@ -195,7 +285,7 @@ func (p *parser) parseEscapeAux(str []rune, inClass bool) (Ast, []rune) {
 		'<', '=', '>', '?', '@', '[', '\\', ']', '^', '_', '`', '{', '|', '}', '~':
 		return AstLit(str[1:2]), str[2:]
 	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
-		if str[2] < '0' || '9' < str[2] {
+		if len(str) < 3 || str[2] < '0' || '9' < str[2] {
 			if str[1] == '0' {
 				return AstLit([]rune{0}), str[2:]
 			}
@@ -204,55 +294,151 @@ func (p *parser) parseEscapeAux(str []rune, inClass bool) (Ast, []rune) {
 			}
 			panic(fmt.Errorf("invalid character %q in octal escape: %q", str[2], string(str)))
 		}
-		if str[3] < '0' || '9' < str[3] {
-			panic(fmt.Errorf("invalid character %q in octal escape: %q", str[3], string(str)))
+		if len(str) < 4 || str[3] < '0' || '9' < str[3] {
+			panic(fmt.Errorf("invalid octal escape: %q", string(str)))
 		}
 		oct, err := strconv.ParseUint(string(str[1:4]), 8, 8)
 		if err != nil {
 			panic(fmt.Errorf("can't parse octal escape in %q: %w", string(str), err))
 		}
 		return AstLit([]rune{rune(oct)}), str[4:]
+	case 'x': // \xHH or \x{HHHH}
+		var hex []rune
+		if len(str) > 2 && str[2] == '{' {
+			end := 3
+			for end < len(str) && str[end] != '}' {
+				end++
+			}
+			if end == len(str) {
+				panic(fmt.Errorf("unmatched '{' in hex escape: %q", string(str)))
+			}
+			hex, str = str[3:end], str[end+1:]
+		} else {
+			if len(str) < 4 {
+				panic(fmt.Errorf("invalid hex escape: %q", string(str)))
+			}
+			hex, str = str[2:4], str[4:]
+		}
+		x, err := strconv.ParseUint(string(hex), 16, 32)
+		if err != nil || x > unicode.MaxRune {
+			panic(fmt.Errorf("invalid hex escape: %q", string(hex)))
+		}
+		return AstLit([]rune{rune(x)}), str
+	case 'd', 'D', 's', 'S', 'w', 'W':
+		var c CharClass = perlClasses[unicode.ToLower(str[1])]
+		if unicode.IsUpper(str[1]) {
+			c = NegateCharClass(c)
+		}
+		strRep := string(str[0:2])
+		if p.fold {
+			c = FoldCharClass(c)
+			strRep = "(?i)" + strRep
+		}
+		return AstCharClass{c, strRep}, str[2:]
 	default:
 		panic(fmt.Errorf("Unknown escape sequence: %q", string(str)))
 	}
 }
 
 func (p *parser) parseClass(str []rune) (Ast, []rune) {
+	origStr := str
+	out, str := p.parseClassSet(str)
+	strRep := string(origStr[0 : len(origStr)-len(str)])
+	if p.fold {
+		strRep = "(?i)" + strRep // Distinguish from the same class without folding
+	}
+	// Return AstLit if it contains only a single character
+	if r, ok := singleCharOf(out); ok {
+		return AstLit(string(r)), str
+	}
+	return AstCharClass{out, strRep}, str
+}
+
+// parseClassSet parses a bracketed class, which may contain nested classes and
+// set operations, i.e. intersection (&&) and subtraction (--). Operators have
+// the same precedence and are left-associative, e.g. [\w--\d&&[a-z]] means
+// [[\w--\d]&&[a-z]].
+func (p *parser) parseClassSet(str []rune) (CharClass, []rune) {
 	if str[0] != '[' {
 		panic(fmt.Errorf("'[' is expected, but cannot find: %q", string(str)))
 	}
 	origStr := str
 	str = str[1:]
-	rangeTable := &unicode.RangeTable{}
-	ccs := []CharClass{}
 	isNegate := false
-	if str[0] == '^' {
+	if len(str) > 0 && str[0] == '^' {
 		isNegate = true
 		str = str[1:]
 	}
-	if str[0] == ']' || str[0] == '-' {
+	out, str := p.parseClassItems(str, origStr, true)
+	for {
+		if len(str) == 0 {
+			panic(fmt.Errorf("unmatched '[' here: %q", string(origStr)))
+		}
+		if str[0] == ']' {
+			str = str[1:]
+			break
+		}
+		op := string(str[0:2]) // This must be either "&&" or "--"
+		var right CharClass
+		right, str = p.parseClassItems(str[2:], origStr, false)
+		if op == "&&" {
+			out = IntersectCharClass(out, right)
+		} else {
+			out = SubtractCharClass(out, right)
+		}
+	}
+	if isNegate {
+		out = NegateCharClass(out)
+	}
+	return out, str
+}
+
+// parseClassItems parses characters, ranges and nested classes in a class up to
+// ']' or a set operator, and returns their union. If head is true, ']' or '-'
+// at the beginning is regarded as a literal.
+func (p *parser) parseClassItems(str []rune, origStr []rune, head bool) (CharClass, []rune) {
+	rangeTable := &unicode.RangeTable{}
+	ccs := []CharClass{}
+	if head && len(str) > 0 && (str[0] == ']' || str[0] == '-') {
 		rangeTable = mergeRangeTable(rangeTable, rangeTableFromTo(str[0], str[0]))
 		str = str[1:]
 	}
+	// "&&" and "--" are operators only if an operand follows, so that "X--"
+	// at the end is still a range, e.g. [+--] means [+,-].
+	isOp := func(str []rune) bool {
+		return len(str) >= 3 && (str[0] == '&' && str[1] == '&' || str[0] == '-' && str[1] == '-') && str[2] != ']'
+	}
+	if isOp(str) {
+		panic(fmt.Errorf("missing operand of set operation in character class: %q", string(origStr)))
+	}
 LOOP:
 	for {
 		if len(str) == 0 {
 			panic(fmt.Errorf("unmatched '[' here: %q", string(origStr)))
 		}
-		if str[0] == ']' {
-			str = str[1:]
+		if str[0] == ']' || isOp(str) {
 			break LOOP
 		}
 		var from rune
-		if str[0] == '\\' {
+		switch str[0] {
+		case '[':
+			var c CharClass
+			c, str = p.parseClassSet(str)
+			ccs = append(ccs, c)
+			continue LOOP
+		case '\\':
 			var re Ast
 			re, str = p.parseEscapeAux(str, true)
+			if c, ok := re.(AstCharClass); ok {
+				ccs = append(ccs, c.CharClass)
+				continue LOOP
+			}
 			from = ([]rune)(re.(AstLit))[0] // This must work at least now
-		} else {
+		default:
 			from = str[0]
 			str = str[1:]
 		}
-		if str[0] != '-' {
+		if len(str) < 2 || str[0] != '-' || isOp(str) {
 			rangeTable = mergeRangeTable(rangeTable, rangeTableFromTo(from, from))
 			continue LOOP
 		}
@@ -260,38 +446,28 @@ LOOP:
 		case ']':
 			rangeTable = mergeRangeTable(rangeTable, rangeTableFromTo(from, from))
 			rangeTable = mergeRangeTable(rangeTable, rangeTableFromTo('-', '-'))
-			str = str[2:]
+			str = str[1:]
 			break LOOP
 		case '\\':
 			var re Ast
 			re, str = p.parseEscapeAux(str[1:], true)
-			to := ([]rune)(re.(AstLit))[0] // This must work at least now
-			rangeTable = mergeRangeTable(rangeTable, rangeTableFromTo(from, to))
-			break
+			lit, ok := re.(AstLit)
+			if !ok {
+				panic(fmt.Errorf("invalid character class range: %q", string(origStr)))
+			}
+			rangeTable = mergeRangeTable(rangeTable, rangeTableFromTo(from, ([]rune)(lit)[0]))
 		default:
+			if from > str[1] {
+				panic(fmt.Errorf("invalid character class range: %q", string(origStr)))
+			}
 			rangeTable = mergeRangeTable(rangeTable, rangeTableFromTo(from, str[1]))
 			str = str[2:]
 		}
 	}
-	strRep := string(origStr[0 : len(origStr)-len(str)])
-	if rangeTable.R16 != nil || rangeTable.R32 != nil {
-		ccs = append(ccs, (*RangeTableClass)(rangeTable))
-	}
-	var out CharClass
-	if len(ccs) == 1 {
-		out = ccs[0]
-	} else {
-		out = CompositeClass(ccs)
-	}
-	// Return AstLit if it contains only a single character
-	if c, ok := out.(*RangeTableClass); ok && !isNegate {
-		if r, ok := c.HasOnlySingleChar(); ok {
-			return AstLit(string(r)), str
-		}
+	ccs = append(ccs, (*RangeTableClass)(rangeTable))
+	out := MergeCharClass(ccs...)
+	if p.fold {
+		out = FoldCharClass(out)
 	}
-	out = toAsciiMaskClass(out) // this returns the input as-is if impossible to convert to asciiMaskClass
-	if isNegate {
-		out = NegateCharClass(out)
-	}
-	return AstCharClass{out, strRep}, str
+	return normalizeCharClass(out), str
 }
@@ -40,3 +40,31 @@ func TestParseFooOrBar(t *testing.T) {
 		t.Errorf("want %q, but got %q", "(?:bar)", seq)
 	}
 }
+
+func TestParseFoldFlag(t *testing.T) {
+	tests := []struct {
+		ptn string
+		out string
+	}{
+		{"(?i)foo", "(?i:foo)"},
+		{"(?i:foo)bar", "(?:(?i:foo)bar)"},
+		{"a(?i)b-c", "(?:a(?i:b-c))"},
+		{"(?i)a(?-i)b", "(?:(?i:a)b)"},
+		{"((?i)a)b", "(?:((?i:a))b)"},
+		{"(?i)123", "123"},
+	}
+	for _, test := range tests {
+		re, err := parse(test.ptn)
+		if err != nil {
+			t.Fatalf("want nil, but got %v", err)
+		}
+		if s := optimizeAst(re).String(); s != test.out {
+			t.Errorf("%q: want %q, but got %q", test.ptn, test.out, s)
+		}
+	}
+	for _, ptn := range []string{"(?)", "(?x)", "(?i-)", "(?-i-i)", "(?i", "(?i)*", "(?i)+", "(?i)?", "(?i){2,}", "a(?-i){2}"} {
+		if _, err := parse(ptn); err == nil {
+			t.Errorf("parse(%q) should fail, but succeeded", ptn)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+//go:build cgo && (linux || darwin || freebsd)
+// +build cgo
+// +build linux darwin freebsd
+
+package yarex
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"plugin"
+	"runtime"
+	"runtime/debug"
+	"sort"
+)
+
+// PluginLoader builds generated matchers for patterns known only at runtime
+// into a Go plugin, and loads it. The plugin registers the matchers with
+// RegisterCompiledRegexp, and thus Compile returns compiled matchers for them
+// as if they were processed by yarexgen.
+//
+// Built plugins are cached in CacheDir keyed by the hash of the patterns,
+// so that the build runs only once per pattern set. Load must be called
+// before Compile is called from other goroutines, e.g. on start-up.
+type PluginLoader struct {
+	// CacheDir is the directory to store built plugins. If empty,
+	// "yarex" directory under os.UserCacheDir() is used.
+	CacheDir string
+	// SourceDir is the directory containing the source of go-yarex. A plugin must
+	// be built from the same source as the running binary. If empty, the directory
+	// recorded in this binary is used, which is not available with -trimpath.
+	SourceDir string
+	// GoCmd is the go command used to build plugins. If empty, "go" is used.
+	GoCmd string
+}
+
+// Load builds and loads a plugin containing matchers for ptns. Patterns that
+// already have compiled matchers are skipped.
+//
+// Patterns whose generated code exceeds DefaultMaxCodeSize are left out of the
+// plugin, and compiled at runtime instead. Load builds the plugin for the rest,
+// and then returns *CodeSizeError listing the patterns left out, with the size
+// of the largest one.
+func (pl *PluginLoader) Load(ptns ...string) error {
+	todo := make([]string, 0, len(ptns))
+	for _, p := range ptns {
+		if _, ok := compiledRegexps[p]; !ok {
+			todo = append(todo, p)
+		}
+	}
+	if len(todo) == 0 {
+		return nil
+	}
+	sort.Strings(todo)
+	gg := NewGoGenerator("plugin.go", "main")
+	added := make([]string, 0, len(todo))
+	var sizeErr *CodeSizeError
+	for _, p := range todo {
+		if err := gg.Add(p); err != nil {
+			e, ok := err.(*CodeSizeError)
+			if !ok {
+				return err
+			}
+			if sizeErr == nil {
+				sizeErr = e
+			} else {
+				sizeErr.Patterns = append(sizeErr.Patterns, e.Patterns...)
+				if e.Size > sizeErr.Size {
+					sizeErr.Size = e.Size
+				}
+			}
+			continue
+		}
+		added = append(added, p)
+	}
+	if err := pl.load(gg, added); err != nil {
+		return err
+	}
+	if sizeErr != nil {
+		return sizeErr
+	}
+	return nil
+}
+
+// load loads the plugin for ptns added to gg from the cache, or builds it.
+func (pl *PluginLoader) load(gg *GoGenerator, ptns []string) error {
+	if len(ptns) == 0 {
+		return nil
+	}
+	dir, err := pl.cacheDir()
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(dir, pluginHash(ptns)+".so")
+	if _, err := os.Stat(file); err == nil {
+		if _, err := plugin.Open(file); err == nil {
+			return nil
+		}
+		// The cached plugin may be built by another version of the binary. Try rebuilding it.
+		os.Remove(file)
+	}
+	if err := pl.build(file, gg); err != nil {
+		return err
+	}
+	if _, err := plugin.Open(file); err != nil {
+		return fmt.Errorf("can't load plugin %s: %w", file, err)
+	}
+	return nil
+}
+
+func (pl *PluginLoader) cacheDir() (string, error) {
+	dir := pl.CacheDir
+	if dir == "" {
+		d, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(d, "yarex")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func (pl *PluginLoader) sourceDir() (string, error) {
+	if pl.SourceDir != "" {
+		return filepath.Abs(pl.SourceDir)
+	}
+	_, file, _, ok := runtime.Caller(0)
+	if !ok || !filepath.IsAbs(file) {
+		return "", fmt.Errorf("can't find the source of go-yarex; set PluginLoader.SourceDir")
+	}
+	return filepath.Dir(file), nil
+}
+
+func (pl *PluginLoader) build(file string, gg *GoGenerator) error {
+	src, err := pl.sourceDir()
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir("", "yarexplugin")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	gomod := fmt.Sprintf("module yarexplugin\n\ngo 1.15\n\nrequire github.com/Maki-Daisuke/go-yarex v0.0.0\n\nreplace github.com/Maki-Daisuke/go-yarex => %s\n", src)
+	if err := ioutil.WriteFile(filepath.Join(tmp, "go.mod"), []byte(gomod), 0644); err != nil {
+		return err
+	}
+	out, err := os.Create(filepath.Join(tmp, "plugin_yarex.go"))
+	if err != nil {
+		return err
+	}
+	if _, err := gg.WriteTo(out); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	gocmd := pl.GoCmd
+	if gocmd == "" {
+		gocmd = "go"
+	}
+	// Build into a temporary file first, and then rename it, so that other
+	// processes never load a half-written plugin.
+	soTmp := filepath.Join(tmp, "plugin.so")
+	cmd := exec.Command(gocmd, "build", "-buildmode=plugin", "-o", soTmp, ".")
+	cmd.Dir = tmp
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod")
+	if msg, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("can't build plugin: %w\n%s", err, msg)
+	}
+	return copyFile(soTmp, file)
+}
+
+// copyFile copies src to dst via a temporary file in the same directory as
+// dst, since src may be in another file system.
+func copyFile(src, dst string) error {
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(dst), ".yarexplugin")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), dst)
+}
+
+// pluginHash returns a cache key for ptns. It also covers the Go version and
+// the module versions of the running binary, because a plugin can be loaded
+// only by the binary built with the same ones.
+func pluginHash(ptns []string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00", runtime.Version())
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		fmt.Fprintf(h, "%s@%s\x00", bi.Main.Path, bi.Main.Version)
+		for _, m := range bi.Deps {
+			fmt.Fprintf(h, "%s@%s %s\x00", m.Path, m.Version, m.Sum)
+		}
+	}
+	for _, p := range ptns {
+		fmt.Fprintf(h, "%s\x00", p)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,18 @@
+//go:build !cgo || (!linux && !darwin && !freebsd)
+// +build !cgo !linux,!darwin,!freebsd
+
+package yarex
+
+import "errors"
+
+// PluginLoader is not supported on this platform. See plugin.go for details.
+type PluginLoader struct {
+	CacheDir  string
+	SourceDir string
+	GoCmd     string
+}
+
+// Load always fails, because Go plugins are not supported on this platform.
+func (pl *PluginLoader) Load(ptns ...string) error {
+	return errors.New("yarex: plugins are not supported on this platform")
+}
@@ -0,0 +1,96 @@
+//go:build cgo && (linux || darwin || freebsd)
+// +build cgo
+// +build linux darwin freebsd
+
+package yarex
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPluginHash(t *testing.T) {
+	h1 := pluginHash([]string{"foo", "bar"})
+	h2 := pluginHash([]string{"foo", "bar"})
+	if h1 != h2 {
+		t.Errorf("pluginHash should be deterministic, but got %q and %q", h1, h2)
+	}
+	if h3 := pluginHash([]string{"foob", "ar"}); h1 == h3 {
+		t.Errorf("pluginHash should distinguish pattern boundaries, but got %q for both", h1)
+	}
+}
+
+// pluginTestMain loads a plugin for a pattern, and prints the type of the
+// matcher returned by Compile and its match. It also passes a pattern too large
+// to generate, which must be reported but not prevent the other from loading. It runs in its own process, since
+// the test binary can't load plugins built with package yarex without tests.
+const pluginTestMain = `package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/Maki-Daisuke/go-yarex"
+)
+
+func main() {
+	ptn := "a(b+|c)d"
+	large := strings.Repeat("a?", 3000)
+	pl := &yarex.PluginLoader{CacheDir: os.Args[1], SourceDir: os.Args[2]}
+	err := pl.Load(ptn, large)
+	if sizeErr, ok := err.(*yarex.CodeSizeError); !ok || !reflect.DeepEqual(sizeErr.Patterns, []string{large}) {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+	re := yarex.MustCompile(ptn)
+	fmt.Println(reflect.ValueOf(re).Elem().FieldByName("exe").Elem().Type(), re.FindString("xabbbdx"))
+}
+`
+
+func TestPluginLoaderLoad(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test to build plugin in short mode")
+	}
+	src, err := filepath.Abs(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp, err := ioutil.TempDir("", "yarexplugintest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	gomod := fmt.Sprintf("module pluginmain\n\ngo 1.15\n\nrequire github.com/Maki-Daisuke/go-yarex v0.0.0\n\nreplace github.com/Maki-Daisuke/go-yarex => %s\n", src)
+	if err := ioutil.WriteFile(filepath.Join(tmp, "go.mod"), []byte(gomod), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmp, "main.go"), []byte(pluginTestMain), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache := filepath.Join(tmp, "cache")
+	for i := 0; i < 2; i++ { // The second run loads the cached plugin.
+		cmd := exec.Command("go", "run", ".", cache, src)
+		cmd.Dir = tmp
+		cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			if strings.Contains(string(out), "-buildmode=plugin not supported") {
+				t.Skipf("plugins are not supported: %s", out)
+			}
+			t.Fatalf("failed to load plugin: %v\n%s", err, out)
+		}
+		if want := "*yarex.compiledExecer abbbd\n"; string(out) != want {
+			t.Errorf("Compile should return the matcher in the plugin: want %q, but got %q", want, out)
+		}
+		if sos, _ := filepath.Glob(filepath.Join(cache, "*.so")); len(sos) != 1 {
+			t.Errorf("plugin should be cached in %s, but found %v", cache, sos)
+		}
+	}
+}
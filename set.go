@@ -0,0 +1,114 @@
+package yarex
+
+import "strings"
+
+// setExecer runs all the member patterns of a Set at once, as a single
+// alternation of the members. exec tries the members not matched yet at pos,
+// and calls onSuccess for each member reaching success, with the context whose
+// last frame is ContextKey{'s', id} for the index id of the member. A member
+// is regarded as matched if ctx has a frame of its key.
+type setExecer interface {
+	exec(ctx MatchContext, pos int, onSuccess func(MatchContext)) bool
+}
+
+// Set is a collection of patterns that are matched against a string at once,
+// like RE2::Set. Patterns are identified by the index in which they are given.
+type Set struct {
+	ptns []string
+	exe  setExecer
+}
+
+// CompileSet compiles all patterns together and returns a Set.
+// If the set has been compiled by yarexgen, the generated matcher is used.
+func CompileSet(ptns ...string) (*Set, error) {
+	if s, ok := compiledSets[setKey(ptns)]; ok {
+		return s, nil
+	}
+	asts := make([]Ast, len(ptns))
+	for i, p := range ptns {
+		ast, err := parse(p)
+		if err != nil {
+			return nil, err
+		}
+		asts[i] = optimizeAst(ast)
+	}
+	return &Set{append([]string{}, ptns...), closureSetExecer{closureCompileSet(asts)}}, nil
+}
+
+func MustCompileSet(ptns ...string) *Set {
+	s, err := CompileSet(ptns...)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// setKey returns a key to look up a compiled Set. NUL is used as a separator
+// so that a list of patterns is not confused with another list.
+func setKey(ptns []string) string {
+	return strings.Join(ptns, "\x00")
+}
+
+func (s *Set) Len() int {
+	return len(s.ptns)
+}
+
+func (s *Set) Patterns() []string {
+	return append([]string{}, s.ptns...)
+}
+
+// MatchString returns IDs of all the patterns matching str in ascending order.
+// It returns nil if no pattern matches.
+//
+// str is scanned only once. At each position, all the patterns not matched yet
+// are tried by a single matcher.
+func (s *Set) MatchString(str string) []int {
+	n := len(s.ptns)
+	matched := make([]bool, n)
+	var found []int // patterns newly matched at the current position
+	sp := opStackPool.Get().(*[]opStackFrame)
+	stack := *sp
+	defer func() { *sp = stack; opStackPool.Put(sp) }()
+	getter := func() []opStackFrame { return stack }
+	setter := func(s []opStackFrame) { stack = s }
+	ctx := makeOpMatchContext(&str, &getter, &setter)
+	onSuccess := func(c MatchContext) {
+		if id := setMember(c); !matched[id] {
+			matched[id] = true
+			found = append(found, id)
+		}
+	}
+	remain := n
+	for p := 0; p <= len(str) && remain > 0; p = nextStart(str, p) {
+		s.exe.exec(ctx.Push(ContextKey{'c', 0}, p), p, onSuccess)
+		// Mark the members matched, so that they are not tried any more.
+		for _, id := range found {
+			ctx = ctx.Push(ContextKey{'s', uint(id)}, p)
+		}
+		remain -= len(found)
+		found = found[:0]
+	}
+	if remain == n {
+		return nil
+	}
+	out := make([]int, 0, n-remain)
+	for id, m := range matched {
+		if m {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// setMember returns the index of the member reported to onSuccess of setExecer.
+func setMember(c MatchContext) int {
+	return int(c.stack()[c.stackTop-1].Key.Index)
+}
+
+type closureSetExecer struct {
+	fun closure
+}
+
+func (ce closureSetExecer) exec(ctx MatchContext, pos int, onSuccess func(MatchContext)) bool {
+	return ce.fun(ctx.Input(), ctx, pos, onSuccess)
+}
@@ -0,0 +1,67 @@
+package yarex_test
+
+//go:generate cmd/yarexgen/yarexgen set_test.go
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/Maki-Daisuke/go-yarex"
+)
+
+func testSet(t *testing.T, ptns []string, tests []string) {
+	stdRes := make([]*regexp.Regexp, len(ptns))
+	for i, p := range ptns {
+		stdRes[i] = regexp.MustCompile(p)
+	}
+	cloSet := yarex.MustCompileSetClosure(ptns...)
+	compSet := yarex.MustCompileSet(ptns...)
+	if !yarex.IsCompiledSet(compSet) {
+		t.Errorf("%q should be Compiled set, but isn't", ptns)
+	}
+	for _, str := range tests {
+		var want []int
+		for i, re := range stdRes {
+			if re.MatchString(str) {
+				want = append(want, i)
+			}
+		}
+		if got := cloSet.MatchString(str); !reflect.DeepEqual(got, want) {
+			t.Errorf("(Closure) %q.MatchString(%q) returned %v, but expected %v", ptns, str, got, want)
+		}
+		if got := compSet.MatchString(str); !reflect.DeepEqual(got, want) {
+			t.Errorf("(Compiled) %q.MatchString(%q) returned %v, but expected %v", ptns, str, got, want)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	ptns := []string{"foo", "^bar", "ba[rz]$", "(?:hoge|fuga)+!", "[0-9]{2,3}"} //yarexgen set
+	testSet(t, ptns, []string{
+		"",
+		"foo",
+		"bar",
+		"foobar",
+		"barfoo",
+		"bazhogefuga!",
+		"fuga!",
+		"1",
+		"abc123",
+		"foo bar baz 42 hoge!",
+	})
+}
+
+func TestSetOverlapping(t *testing.T) {
+	ptns := []string{"a+", "a+b", "^a", "b$", "(a|ab)(c|bcd)(d*)", "x"} //yarexgen set
+	testSet(t, ptns, []string{
+		"",
+		"a",
+		"ab",
+		"ba",
+		"cab",
+		"abcd",
+		"xabcdb",
+		"bbbbbbbbbbbbbbbbx",
+	})
+}
@@ -0,0 +1,166 @@
+package yarex
+
+import (
+	"fmt"
+
+	"github.com/Maki-Daisuke/go-yarex/syntax"
+)
+
+// ParseSyntax parses ptn and returns its syntax tree.
+func ParseSyntax(ptn string) (*syntax.Node, error) {
+	ast, err := parse(ptn)
+	if err != nil {
+		return nil, err
+	}
+	return astToSyntax(ast), nil
+}
+
+// CompileSyntax compiles a syntax tree, which may be built by hand with package
+// syntax, into Regexp. String() of the returned Regexp is the pattern printed
+// from the tree.
+func CompileSyntax(n *syntax.Node) (re *Regexp, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			se, ok := e.(invalidSyntaxError)
+			if !ok {
+				panic(e) // Not an error in n, but a bug.
+			}
+			err = se.error
+			re = nil
+		}
+	}()
+	var index uint
+	ast := syntaxToAst(n, &index)
+	return compileAst(ast.String(), ast), nil
+}
+
+func MustCompileSyntax(n *syntax.Node) *Regexp {
+	r, err := CompileSyntax(n)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// invalidSyntaxError is an error in a syntax tree given to CompileSyntax, which
+// is thrown by syntaxToAst with panic.
+type invalidSyntaxError struct {
+	error
+}
+
+func astToSyntax(re Ast) *syntax.Node {
+	switch r := re.(type) {
+	case AstLit:
+		if len(r) == 0 {
+			return syntax.EmptyMatch()
+		}
+		return syntax.Literal(string(r))
+	case AstFoldLit:
+		return syntax.FoldLiteral(string(r))
+	case AstNotNewline:
+		return syntax.AnyCharNotNL()
+	case AstAssertBegin:
+		return syntax.BeginText()
+	case AstAssertEnd:
+		return syntax.EndText()
+	case AstBackRef:
+		return syntax.BackRef(int(r))
+	case AstCharClass:
+		rs, ok := classRanges(r.CharClass)
+		if !ok {
+			rs = scanRanges(r.CharClass)
+		}
+		pairs := make([]rune, 0, len(rs)*2)
+		for _, x := range rs {
+			pairs = append(pairs, x.lo, x.hi)
+		}
+		return syntax.CharClass(pairs...)
+	case *AstCap:
+		if r.name != "" {
+			return syntax.NamedCapture(r.name, astToSyntax(r.re))
+		}
+		return syntax.Capture(astToSyntax(r.re))
+	case *AstRepeat:
+		return syntax.Repeat(astToSyntax(r.re), r.min, r.max)
+	case *AstSeq:
+		subs := make([]*syntax.Node, len(r.seq))
+		for i, s := range r.seq {
+			subs[i] = astToSyntax(s)
+		}
+		return syntax.Concat(subs...)
+	case *AstAlt:
+		subs := make([]*syntax.Node, len(r.opts))
+		for i, s := range r.opts {
+			subs[i] = astToSyntax(s)
+		}
+		return syntax.Alternate(subs...)
+	}
+	panic(fmt.Errorf("IMPLEMENT ME: conversion of %T", re))
+}
+
+// syntaxToAst converts n into Ast. index is the number of capturing groups
+// which have already been opened, and is used to number groups in n.
+func syntaxToAst(n *syntax.Node, index *uint) Ast {
+	if n == nil {
+		panic(invalidSyntaxError{fmt.Errorf("nil node in syntax tree")})
+	}
+	switch n.Op() {
+	case syntax.OpEmptyMatch:
+		return AstLit("")
+	case syntax.OpLiteral:
+		return AstLit(n.Lit())
+	case syntax.OpFoldLiteral:
+		return AstFoldLit(n.Lit())
+	case syntax.OpAnyCharNotNL:
+		return AstNotNewline{}
+	case syntax.OpBeginText:
+		return AstAssertBegin{}
+	case syntax.OpEndText:
+		return AstAssertEnd{}
+	case syntax.OpBackRef:
+		return AstBackRef(n.Index())
+	case syntax.OpCharClass:
+		return charClassOfSyntax(n.Ranges())
+	case syntax.OpCapture:
+		*index++
+		i := *index
+		return &AstCap{i, syntaxToAst(n.Sub()[0], index), n.Name()}
+	case syntax.OpRepeat:
+		return &AstRepeat{syntaxToAst(n.Sub()[0], index), n.Min(), n.Max()}
+	case syntax.OpConcat:
+		if len(n.Sub()) == 0 {
+			return AstLit("")
+		}
+		seq := make([]Ast, len(n.Sub()))
+		for i, s := range n.Sub() {
+			seq[i] = syntaxToAst(s, index)
+		}
+		return &AstSeq{seq}
+	case syntax.OpAlternate:
+		switch len(n.Sub()) {
+		case 0:
+			return charClassOfSyntax(nil) // matches nothing
+		case 1:
+			return syntaxToAst(n.Sub()[0], index)
+		}
+		opts := make([]Ast, len(n.Sub()))
+		for i, s := range n.Sub() {
+			opts[i] = syntaxToAst(s, index)
+		}
+		return &AstAlt{opts}
+	}
+	panic(invalidSyntaxError{fmt.Errorf("unknown op in syntax tree: %v", n.Op())})
+}
+
+// charClassOfSyntax converts ranges of syntax.OpCharClass into AstCharClass.
+func charClassOfSyntax(pairs []rune) Ast {
+	rs := make([]runeRange, len(pairs)/2)
+	for i := range rs {
+		rs[i] = runeRange{pairs[2*i], pairs[2*i+1]}
+	}
+	c := classOfRanges(rs)
+	if r, ok := singleCharOf(c); ok {
+		return AstLit(string(r))
+	}
+	return AstCharClass{c, c.String()}
+}
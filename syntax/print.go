@@ -0,0 +1,181 @@
+package syntax
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Precedence of the context in which a node is printed.
+const (
+	precAlternate = iota
+	precConcat
+	precRepeat
+)
+
+// String returns the pattern of n in the syntax of yarex.
+func (n *Node) String() string {
+	var buf strings.Builder
+	writeNode(&buf, n, precAlternate)
+	return buf.String()
+}
+
+func writeNode(buf *strings.Builder, n *Node, prec int) {
+	switch n.op {
+	case OpEmptyMatch:
+		buf.WriteString("(?:)")
+	case OpLiteral:
+		if n.lit == "" {
+			buf.WriteString("(?:)")
+			return
+		}
+		group := prec == precRepeat && utf8.RuneCountInString(n.lit) > 1
+		if group {
+			buf.WriteString("(?:")
+		}
+		for _, r := range n.lit {
+			buf.WriteString(quoteRune(r, false))
+		}
+		if group {
+			buf.WriteString(")")
+		}
+	case OpFoldLiteral:
+		if n.lit == "" {
+			buf.WriteString("(?:)")
+			return
+		}
+		buf.WriteString("(?i:")
+		for _, r := range n.lit {
+			buf.WriteString(quoteRune(r, false))
+		}
+		buf.WriteString(")")
+	case OpCharClass:
+		writeClass(buf, n.ranges)
+	case OpAnyCharNotNL:
+		buf.WriteString(".")
+	case OpBeginText, OpEndText:
+		s := "^"
+		if n.op == OpEndText {
+			s = "$"
+		}
+		if prec == precRepeat {
+			s = "(?:" + s + ")"
+		}
+		buf.WriteString(s)
+	case OpCapture:
+		buf.WriteString("(")
+		if n.name != "" {
+			buf.WriteString("?P<" + n.name + ">")
+		}
+		writeNode(buf, n.sub[0], precAlternate)
+		buf.WriteString(")")
+	case OpBackRef:
+		fmt.Fprintf(buf, `(?:\%d)`, n.index) // Enclosed not to be followed by digits
+	case OpRepeat:
+		if prec == precRepeat {
+			buf.WriteString("(?:")
+		}
+		writeNode(buf, n.sub[0], precRepeat)
+		switch {
+		case n.min == 0 && n.max == 1:
+			buf.WriteString("?")
+		case n.min == 0 && n.max < 0:
+			buf.WriteString("*")
+		case n.min == 1 && n.max < 0:
+			buf.WriteString("+")
+		case n.min == n.max:
+			fmt.Fprintf(buf, "{%d}", n.min)
+		case n.max < 0:
+			fmt.Fprintf(buf, "{%d,}", n.min)
+		default:
+			fmt.Fprintf(buf, "{%d,%d}", n.min, n.max)
+		}
+		if prec == precRepeat {
+			buf.WriteString(")")
+		}
+	case OpConcat:
+		if len(n.sub) == 0 {
+			buf.WriteString("(?:)")
+			return
+		}
+		if prec == precRepeat {
+			buf.WriteString("(?:")
+		}
+		for _, s := range n.sub {
+			writeNode(buf, s, precConcat)
+		}
+		if prec == precRepeat {
+			buf.WriteString(")")
+		}
+	case OpAlternate:
+		if len(n.sub) == 0 {
+			writeClass(buf, nil) // matches nothing
+			return
+		}
+		if prec != precAlternate {
+			buf.WriteString("(?:")
+		}
+		for i, s := range n.sub {
+			if i > 0 {
+				buf.WriteString("|")
+			}
+			writeNode(buf, s, precAlternate)
+		}
+		if prec != precAlternate {
+			buf.WriteString(")")
+		}
+	default:
+		panic(fmt.Errorf("syntax: unknown op: %v", n.op))
+	}
+}
+
+// writeClass writes a bracket expression of ranges. A class containing
+// unicode.MaxRune is written as its complement, e.g. [^a-z].
+func writeClass(buf *strings.Builder, ranges []rune) {
+	if len(ranges) == 0 {
+		buf.WriteString(`[^\x{0}-\x{10ffff}]`)
+		return
+	}
+	buf.WriteString("[")
+	if last := ranges[len(ranges)-1]; last == unicode.MaxRune && (len(ranges) > 2 || ranges[0] != 0) {
+		buf.WriteString("^")
+		var comp []rune
+		next := rune(0)
+		for i := 0; i < len(ranges); i += 2 {
+			if next < ranges[i] {
+				comp = append(comp, next, ranges[i]-1)
+			}
+			next = ranges[i+1] + 1
+		}
+		ranges = comp
+	}
+	for i := 0; i < len(ranges); i += 2 {
+		lo, hi := ranges[i], ranges[i+1]
+		buf.WriteString(quoteRune(lo, true))
+		if lo == hi {
+			continue
+		}
+		if lo+1 != hi {
+			buf.WriteString("-")
+		}
+		buf.WriteString(quoteRune(hi, true))
+	}
+	buf.WriteString("]")
+}
+
+// quoteRune returns r escaped if necessary. Escaped characters differ in and
+// out of a bracket expression, which is specified by inClass.
+func quoteRune(r rune, inClass bool) string {
+	if !unicode.IsPrint(r) || r == utf8.RuneError {
+		return fmt.Sprintf(`\x{%x}`, r)
+	}
+	meta := `\.+*?()|[]{}^$`
+	if inClass {
+		meta = `\[]^-&`
+	}
+	if strings.ContainsRune(meta, r) {
+		return `\` + string(r)
+	}
+	return string(r)
+}
@@ -0,0 +1,107 @@
+package syntax
+
+// Simplify returns a tree equivalent to n with redundancy removed. n is not
+// modified. Simplify does the following:
+//
+//   - flattens nested concatenations and alternations,
+//   - removes empty matches and joins adjacent literals in concatenations,
+//   - replaces classes of a single character with literals,
+//   - removes trivial repeats, e.g. x{1}, and merges nested ones, e.g. (?:x+)*.
+//
+// Capturing groups are never removed, so that the group indices are kept.
+func Simplify(n *Node) *Node {
+	switch n.op {
+	case OpLiteral, OpFoldLiteral:
+		if n.lit == "" {
+			return EmptyMatch()
+		}
+	case OpCharClass:
+		if len(n.ranges) == 2 && n.ranges[0] == n.ranges[1] {
+			return Literal(string(n.ranges[0]))
+		}
+	case OpCapture:
+		return &Node{op: OpCapture, sub: []*Node{Simplify(n.sub[0])}, name: n.name}
+	case OpRepeat:
+		return simplifyRepeat(Simplify(n.sub[0]), n.min, n.max)
+	case OpConcat:
+		var subs []*Node
+		for _, s := range n.sub {
+			s = Simplify(s)
+			if s.op == OpConcat {
+				for _, t := range s.sub {
+					subs = appendConcat(subs, t)
+				}
+			} else {
+				subs = appendConcat(subs, s)
+			}
+		}
+		switch len(subs) {
+		case 0:
+			return EmptyMatch()
+		case 1:
+			return subs[0]
+		}
+		return &Node{op: OpConcat, sub: subs}
+	case OpAlternate:
+		var subs []*Node
+		for _, s := range n.sub {
+			s = Simplify(s)
+			if s.op == OpAlternate {
+				subs = append(subs, s.sub...)
+			} else {
+				subs = append(subs, s)
+			}
+		}
+		if len(subs) == 1 {
+			return subs[0]
+		}
+		return &Node{op: OpAlternate, sub: subs}
+	}
+	return n
+}
+
+// appendConcat appends simplified n to subs of a concatenation, joining it with
+// the last one if both are literals of the same kind.
+func appendConcat(subs []*Node, n *Node) []*Node {
+	if n.op == OpEmptyMatch {
+		return subs
+	}
+	if last := len(subs) - 1; last >= 0 && subs[last].op == n.op && (n.op == OpLiteral || n.op == OpFoldLiteral) {
+		subs[last] = &Node{op: n.op, lit: subs[last].lit + n.lit}
+		return subs
+	}
+	return append(subs, n)
+}
+
+func simplifyRepeat(sub *Node, min, max int) *Node {
+	switch {
+	case min == 1 && max == 1:
+		return sub
+	case sub.op == OpEmptyMatch:
+		return sub
+	case min == 0 && max == 0 && !hasCapture(sub):
+		return EmptyMatch()
+	case sub.op == OpRepeat && isSimpleRepeat(min, max) && isSimpleRepeat(sub.min, sub.max):
+		if min == sub.min && max == sub.max { // x** = x*, x++ = x+ and x?? = x?
+			return sub
+		}
+		return Repeat(sub.sub[0], 0, -1) // Any other combination is x*, e.g. (?:x+)? = x*
+	}
+	return Repeat(sub, min, max)
+}
+
+// isSimpleRepeat reports whether {min,max} is one of *, + and ?.
+func isSimpleRepeat(min, max int) bool {
+	return min == 0 && max < 0 || min == 1 && max < 0 || min == 0 && max == 1
+}
+
+func hasCapture(n *Node) bool {
+	found := false
+	Inspect(n, func(n *Node) bool {
+		if n != nil && n.op == OpCapture {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
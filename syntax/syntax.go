@@ -0,0 +1,228 @@
+// Package syntax provides the syntax tree of yarex patterns, which can be
+// built by hand, inspected and transformed. It is similar to regexp/syntax.
+//
+// Use yarex.ParseSyntax to get the syntax tree of a pattern string, and
+// yarex.CompileSyntax to compile a syntax tree into yarex.Regexp.
+package syntax
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Op is the kind of a Node.
+type Op uint8
+
+const (
+	OpEmptyMatch   Op = iota // matches empty string
+	OpLiteral                // matches Lit()
+	OpFoldLiteral            // matches Lit() ignoring case
+	OpCharClass              // matches a character in Ranges()
+	OpAnyCharNotNL           // matches any character except newline
+	OpBeginText              // matches empty string at beginning of text
+	OpEndText                // matches empty string at end of text
+	OpCapture                // capturing group of Sub()[0]
+	OpBackRef                // matches the string captured by the group of Index()
+	OpRepeat                 // matches Sub()[0] from Min() to Max() times
+	OpConcat                 // matches concatenation of Sub()
+	OpAlternate              // matches alternation of Sub()
+)
+
+var opNames = []string{
+	OpEmptyMatch:   "EmptyMatch",
+	OpLiteral:      "Literal",
+	OpFoldLiteral:  "FoldLiteral",
+	OpCharClass:    "CharClass",
+	OpAnyCharNotNL: "AnyCharNotNL",
+	OpBeginText:    "BeginText",
+	OpEndText:      "EndText",
+	OpCapture:      "Capture",
+	OpBackRef:      "BackRef",
+	OpRepeat:       "Repeat",
+	OpConcat:       "Concat",
+	OpAlternate:    "Alternate",
+}
+
+func (op Op) String() string {
+	if int(op) < len(opNames) {
+		return opNames[op]
+	}
+	return fmt.Sprintf("Op(%d)", uint8(op))
+}
+
+// Node is a node of a syntax tree. Node is immutable once it is constructed,
+// and can be shared among multiple trees.
+//
+// Capturing groups are numbered from 1 in the order of their opening
+// parentheses, that is, in pre-order of the tree.
+type Node struct {
+	op       Op
+	lit      string
+	ranges   []rune
+	sub      []*Node
+	min, max int
+	index    int
+	name     string
+}
+
+// EmptyMatch returns a Node matching empty string.
+func EmptyMatch() *Node {
+	return &Node{op: OpEmptyMatch}
+}
+
+// Literal returns a Node matching s. Literal("") is equivalent to EmptyMatch().
+func Literal(s string) *Node {
+	return &Node{op: OpLiteral, lit: s}
+}
+
+// FoldLiteral returns a Node matching s ignoring case, i.e. under simple case
+// folding as (?i) flag.
+func FoldLiteral(s string) *Node {
+	return &Node{op: OpFoldLiteral, lit: s}
+}
+
+// CharClass returns a Node matching a character in ranges, which are pairs of
+// the lowest and highest characters, e.g. CharClass('0', '9', 'a', 'f').
+// The ranges may be unsorted and overlapping. CharClass() matches nothing.
+// It panics if len(ranges) is odd or any pair is reversed.
+func CharClass(ranges ...rune) *Node {
+	if len(ranges)%2 != 0 {
+		panic(fmt.Errorf("syntax: odd number of runes in ranges: %q", ranges))
+	}
+	return &Node{op: OpCharClass, ranges: normalizeRanges(ranges)}
+}
+
+// AnyCharNotNL returns a Node matching any character except newline, i.e. '.'.
+func AnyCharNotNL() *Node {
+	return &Node{op: OpAnyCharNotNL}
+}
+
+// BeginText returns a Node matching at beginning of text, i.e. '^'.
+func BeginText() *Node {
+	return &Node{op: OpBeginText}
+}
+
+// EndText returns a Node matching at end of text, i.e. '$'.
+func EndText() *Node {
+	return &Node{op: OpEndText}
+}
+
+// Capture returns a capturing group of sub.
+func Capture(sub *Node) *Node {
+	return &Node{op: OpCapture, sub: []*Node{sub}}
+}
+
+// NamedCapture returns a capturing group of sub named name, i.e. (?P<name>sub).
+// It panics if name is empty or contains non-word characters.
+func NamedCapture(name string, sub *Node) *Node {
+	if name == "" || strings.IndexFunc(name, func(r rune) bool { return !isWordChar(r) }) >= 0 {
+		panic(fmt.Errorf("syntax: invalid group name: %q", name))
+	}
+	return &Node{op: OpCapture, sub: []*Node{sub}, name: name}
+}
+
+func isWordChar(r rune) bool {
+	return '0' <= r && r <= '9' || 'A' <= r && r <= 'Z' || 'a' <= r && r <= 'z' || r == '_'
+}
+
+// BackRef returns a Node matching the string captured by the index-th group.
+// It panics if index is not positive.
+func BackRef(index int) *Node {
+	if index <= 0 {
+		panic(fmt.Errorf("syntax: invalid group index of back-reference: %d", index))
+	}
+	return &Node{op: OpBackRef, index: index}
+}
+
+// Repeat returns a Node matching sub from min to max times. Negative max means
+// unlimited, e.g. Repeat(x, 1, -1) is equivalent to x+.
+// It panics if min is negative or greater than max.
+func Repeat(sub *Node, min, max int) *Node {
+	if min < 0 || max >= 0 && min > max {
+		panic(fmt.Errorf("syntax: invalid repeat count: {%d,%d}", min, max))
+	}
+	if max < 0 {
+		max = -1
+	}
+	return &Node{op: OpRepeat, sub: []*Node{sub}, min: min, max: max}
+}
+
+// Concat returns a Node matching concatenation of subs.
+// Concat() is equivalent to EmptyMatch().
+func Concat(subs ...*Node) *Node {
+	return &Node{op: OpConcat, sub: append([]*Node(nil), subs...)}
+}
+
+// Alternate returns a Node matching any of subs, which are tried from left to
+// right. Alternate() matches nothing.
+func Alternate(subs ...*Node) *Node {
+	return &Node{op: OpAlternate, sub: append([]*Node(nil), subs...)}
+}
+
+// Op returns the kind of n.
+func (n *Node) Op() Op {
+	return n.op
+}
+
+// Lit returns the string of OpLiteral and OpFoldLiteral.
+func (n *Node) Lit() string {
+	return n.lit
+}
+
+// Ranges returns the sorted and non-overlapping ranges of OpCharClass as pairs
+// of the lowest and highest characters. The caller must not modify it.
+func (n *Node) Ranges() []rune {
+	return n.ranges
+}
+
+// Sub returns the sub-nodes of OpCapture, OpRepeat, OpConcat and OpAlternate.
+// The caller must not modify it.
+func (n *Node) Sub() []*Node {
+	return n.sub
+}
+
+// Min returns the minimum count of OpRepeat.
+func (n *Node) Min() int {
+	return n.min
+}
+
+// Max returns the maximum count of OpRepeat, or -1 if unlimited.
+func (n *Node) Max() int {
+	return n.max
+}
+
+// Name returns the name of OpCapture, or empty string if it is not named.
+func (n *Node) Name() string {
+	return n.name
+}
+
+// Index returns the group index which OpBackRef refers to.
+func (n *Node) Index() int {
+	return n.index
+}
+
+// normalizeRanges sorts rs and merges overlapping and adjacent ranges in it.
+func normalizeRanges(rs []rune) []rune {
+	type pair struct{ lo, hi rune }
+	ps := make([]pair, 0, len(rs)/2)
+	for i := 0; i < len(rs); i += 2 {
+		if rs[i] > rs[i+1] || rs[i] < 0 || rs[i+1] > unicode.MaxRune {
+			panic(fmt.Errorf("syntax: invalid character range: %q-%q", rs[i], rs[i+1]))
+		}
+		ps = append(ps, pair{rs[i], rs[i+1]})
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].lo < ps[j].lo })
+	out := make([]rune, 0, len(rs))
+	for _, p := range ps {
+		if n := len(out); n > 0 && p.lo <= out[n-1]+1 {
+			if p.hi > out[n-1] {
+				out[n-1] = p.hi
+			}
+			continue
+		}
+		out = append(out, p.lo, p.hi)
+	}
+	return out
+}
@@ -0,0 +1,90 @@
+package syntax
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCharClassRanges(t *testing.T) {
+	c := CharClass('x', 'z', 'a', 'c', 'b', 'f', 'g', 'g', '0', '0')
+	if want := []rune{'0', '0', 'a', 'g', 'x', 'z'}; !reflect.DeepEqual(c.Ranges(), want) {
+		t.Errorf("want %q, but got %q", want, c.Ranges())
+	}
+	for _, rs := range [][]rune{{'a'}, {'z', 'a'}, {-1, 'a'}, {'a', 0x110000}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("CharClass(%q) should panic, but did not", rs)
+				}
+			}()
+			CharClass(rs...)
+		}()
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		node *Node
+		want string
+	}{
+		{Literal("a.b"), `a\.b`},
+		{Repeat(Literal("ab"), 0, -1), `(?:ab)*`},
+		{Repeat(Literal("a"), 2, -1), `a{2,}`},
+		{Repeat(Repeat(Literal("a"), 1, -1), 0, 1), `(?:a+)?`},
+		{Concat(Capture(Alternate(Literal("a"), Literal("b"))), BackRef(1), Literal("0")), `(a|b)(?:\1)0`},
+		{Concat(Literal("a"), Alternate(Literal("b"), EmptyMatch())), `a(?:b|(?:))`},
+		{Repeat(Concat(BeginText(), AnyCharNotNL()), 0, 2), `(?:^.){0,2}`},
+		{FoldLiteral("abc"), `(?i:abc)`},
+		{CharClass('a', 'z', '-', '-', 0, 0x1f), `[\x{0}-\x{1f}\-a-z]`},
+		{CharClass('b', 0x10ffff), `[^\x{0}-a]`},
+		{CharClass(), `[^\x{0}-\x{10ffff}]`},
+		{Alternate(), `[^\x{0}-\x{10ffff}]`},
+		{Repeat(EndText(), 1, -1), `(?:$)+`},
+		{NamedCapture("x", Literal("a")), `(?P<x>a)`},
+	}
+	for _, test := range tests {
+		if s := test.node.String(); s != test.want {
+			t.Errorf("want %q, but got %q", test.want, s)
+		}
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	tests := []struct {
+		node *Node
+		want string
+	}{
+		{Concat(Literal("a"), Concat(Literal("b"), EmptyMatch()), Literal(""), CharClass('c', 'c')), `abc`},
+		{Concat(FoldLiteral("a"), FoldLiteral("b"), Literal("c")), `(?i:ab)c`},
+		{Alternate(Literal("a"), Alternate(Literal("b"), Literal("c"))), `a|b|c`},
+		{Alternate(Concat(Literal("a"))), `a`},
+		{Repeat(Literal("a"), 1, 1), `a`},
+		{Repeat(Literal("a"), 0, 0), `(?:)`},
+		{Repeat(Capture(Literal("a")), 0, 0), `(a){0}`},
+		{Repeat(Repeat(Literal("a"), 0, -1), 0, -1), `a*`},
+		{Repeat(Repeat(Literal("a"), 1, -1), 0, 1), `a*`},
+		{Repeat(Repeat(Literal("a"), 2, 3), 0, 1), `(?:a{2,3})?`},
+		{Capture(Repeat(EmptyMatch(), 0, -1)), `((?:))`},
+	}
+	for _, test := range tests {
+		if s := Simplify(test.node).String(); s != test.want {
+			t.Errorf("Simplify(%v) should be %q, but got %q", test.node, test.want, s)
+		}
+	}
+}
+
+func TestInspect(t *testing.T) {
+	n := Concat(Capture(Literal("a")), Repeat(Alternate(Literal("b"), AnyCharNotNL()), 0, -1))
+	var ops []Op
+	Inspect(n, func(n *Node) bool {
+		if n == nil {
+			return false
+		}
+		ops = append(ops, n.Op())
+		return n.Op() != OpCapture // Skip the children of capturing groups
+	})
+	want := []Op{OpConcat, OpCapture, OpRepeat, OpAlternate, OpLiteral, OpAnyCharNotNL}
+	if !reflect.DeepEqual(ops, want) {
+		t.Errorf("want %v, but got %v", want, ops)
+	}
+}
@@ -0,0 +1,35 @@
+package syntax
+
+// A Visitor's Visit method is invoked for each node encountered by Walk.
+// If the result visitor w is not nil, Walk visits each of the children of node
+// with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(n *Node) (w Visitor)
+}
+
+// Walk traverses a syntax tree in depth-first order as go/ast.Walk does.
+func Walk(v Visitor, n *Node) {
+	if v = v.Visit(n); v == nil {
+		return
+	}
+	for _, s := range n.sub {
+		Walk(v, s)
+	}
+	v.Visit(nil)
+}
+
+type inspector func(*Node) bool
+
+func (f inspector) Visit(n *Node) Visitor {
+	if f(n) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses a syntax tree in depth-first order. It calls f(n) for each
+// node, and then traverses the children of n if f(n) returns true, followed by
+// a call of f(nil).
+func Inspect(n *Node, f func(*Node) bool) {
+	Walk(inspector(f), n)
+}
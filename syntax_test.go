@@ -0,0 +1,97 @@
+package yarex_test
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/Maki-Daisuke/go-yarex"
+	"github.com/Maki-Daisuke/go-yarex/syntax"
+)
+
+func TestParseSyntax(t *testing.T) {
+	n, err := yarex.ParseSyntax(`(a|[b-d])\1x*`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := n.String(); s != `(a|[b-d])(?:\1)x*` {
+		t.Errorf("want %q, but got %q", `(a|[b-d])(?:\1)x*`, s)
+	}
+	var caps, lits int
+	syntax.Inspect(n, func(n *syntax.Node) bool {
+		if n == nil {
+			return false
+		}
+		switch n.Op() {
+		case syntax.OpCapture:
+			caps++
+		case syntax.OpLiteral:
+			lits++
+		}
+		return true
+	})
+	if caps != 1 || lits != 2 {
+		t.Errorf("want 1 group and 2 literals, but got %d groups and %d literals", caps, lits)
+	}
+	if _, err := yarex.ParseSyntax(`(a`); err == nil {
+		t.Errorf("ParseSyntax(%q) should fail, but did not", `(a`)
+	}
+}
+
+func TestCompileSyntax(t *testing.T) {
+	tests := []struct {
+		node *syntax.Node
+		std  string
+	}{
+		{
+			syntax.Concat(syntax.Literal("foo"), syntax.Repeat(syntax.CharClass('0', '9'), 1, -1)),
+			`foo[0-9]+`,
+		},
+		{
+			syntax.Alternate(syntax.Concat(syntax.BeginText(), syntax.FoldLiteral("ab")), syntax.Concat(syntax.AnyCharNotNL(), syntax.EndText())),
+			`^(?i:ab)|.$`,
+		},
+		{
+			syntax.Repeat(syntax.Alternate(syntax.Literal("a"), syntax.EmptyMatch(), syntax.CharClass('x', 0x10ffff)), 2, 3),
+			`(?:a|(?:)|[x-\x{10ffff}]){2,3}`,
+		},
+		{
+			syntax.Concat(syntax.Literal("a"), syntax.Alternate(), syntax.CharClass('b', 'b')),
+			`a[^\x{0}-\x{10ffff}]b`,
+		},
+	}
+	for _, test := range tests {
+		re := yarex.MustCompileSyntax(test.node)
+		std := regexp.MustCompile(test.std)
+		for _, str := range []string{"", "foo", "foo123", "xfoo1", "ab", "AB!", "zz", "a\n", "ab", "aaa", "x\U0010ffffa"} {
+			if loc, want := re.FindStringIndex(str), std.FindStringIndex(str); !reflect.DeepEqual(loc, want) {
+				t.Errorf("%v.FindStringIndex(%q) returned %v, but expected %v", re, str, loc, want)
+			}
+		}
+	}
+	re := yarex.MustCompileSyntax(syntax.Concat(syntax.Capture(syntax.Repeat(syntax.CharClass('a', 'z'), 1, -1)), syntax.Literal("-"), syntax.BackRef(1)))
+	if s := re.FindString("x foo-foo"); s != "foo-foo" {
+		t.Errorf("%v.FindString(%q) returned %q, but expected %q", re, "x foo-foo", s, "foo-foo")
+	}
+	if _, err := yarex.CompileSyntax(syntax.Concat(syntax.Literal("a"), nil)); err == nil {
+		t.Errorf("CompileSyntax should fail for a tree containing nil, but did not")
+	}
+}
+
+func TestSyntaxRoundTrip(t *testing.T) {
+	for _, ptn := range []string{`foo|bar`, `(a+)(b*)\2`, `^[^a-z]?x{2,}$`, `(?i)[a-c]x`, `a(?:bc)*d`} {
+		n, err := yarex.ParseSyntax(ptn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := yarex.MustCompile(ptn)
+		for _, n := range []*syntax.Node{n, syntax.Simplify(n)} {
+			re := yarex.MustCompileSyntax(n)
+			for _, str := range []string{"foo", "xbar", "aabb", "abbb", "xx", "Axx", "1xxx", "abcbcd", "ad", "ABX"} {
+				if loc, want := re.FindStringIndex(str), want.FindStringIndex(str); !reflect.DeepEqual(loc, want) {
+					t.Errorf("%v.FindStringIndex(%q) returned %v, but expected %v", re, str, loc, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package yarex_test
+
+//go:generate cmd/yarexgen/yarexgen utf8_test.go
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Maki-Daisuke/go-yarex"
+)
+
+func TestMatchInvalidUTF8(t *testing.T) {
+	tests := []string{
+		"\xff",
+		"\xff\xfe",
+		"a\xffb",
+		"a\xe3\x81b",
+		"a\xe3\x81\x82b",
+		"\xe3\x81",
+		"\xed\xa0\x80",
+		"x\xc0\xafyz",
+		"abc",
+		"",
+	}
+	re := "a.b" //yarexgen
+	testMatchStrings(t, re, tests)
+	testAPIs(t, re, tests)
+	re = "^.$" //yarexgen
+	testMatchStrings(t, re, tests)
+	testAPIs(t, re, tests)
+	re = "^[^a]{2}$" //yarexgen
+	testMatchStrings(t, re, tests)
+	testAPIs(t, re, tests)
+	re = "[^a-z]+" //yarexgen
+	testMatchStrings(t, re, tests)
+	testAPIs(t, re, tests)
+	re = "[^a-z]+y" //yarexgen
+	testMatchStrings(t, re, tests)
+	testAPIs(t, re, tests)
+	re = "^(?:a|[^a])*b" //yarexgen
+	testMatchStrings(t, re, tests)
+	testAPIs(t, re, tests)
+	testBitParallel(t, "a.b", tests)
+	testBitParallel(t, "^[^a]{2}$", tests)
+	testOnePass(t, "^[^a]{2}$", tests)
+	re = "^x.(?:y|z)" //yarexgen
+	testOnePass(t, re, tests)
+}
+
+func TestLatin1(t *testing.T) {
+	tests := []struct {
+		ptn string
+		str string
+		loc []int
+	}{
+		{`^.$`, "\xff", []int{0, 1}},
+		{`^.$`, "é", nil}, // 2 bytes in UTF-8
+		{`^..$`, "é", []int{0, 2}},
+		{`[\200-\377]+`, "abc\x80\xfe\xffdef", []int{3, 6}},
+		{`[^\000-\177]`, "abc\xe3\x81\x82", []int{3, 4}},
+		{`\377\376`, "a\xff\xfeb", []int{1, 3}},
+		{`é`, "\xe9", []int{0, 1}},
+		{`é`, "é", nil},
+		{`a[b-y\377]*z`, "ab\xffcz", []int{0, 5}},
+		{`\001.\002`, "\x01\n\x02\x01\x80\x02", []int{3, 6}},
+		{`(?i)é+`, "a\xc9\xe9", []int{1, 3}},
+	}
+	for _, test := range tests {
+		re := yarex.MustCompileLatin1(test.ptn)
+		if loc := re.FindStringIndex(test.str); !reflect.DeepEqual(loc, test.loc) {
+			t.Errorf("(Latin1) %v.FindStringIndex(%q) returned %v, but expected %v", re, test.str, loc, test.loc)
+		}
+		if re.MatchString(test.str) != (test.loc != nil) {
+			t.Errorf("(Latin1) %v.MatchString(%q) should be %t, but actually not", re, test.str, test.loc != nil)
+		}
+	}
+	if _, err := yarex.CompileLatin1("あ"); err == nil {
+		t.Errorf("CompileLatin1(%q) should fail, but succeeded", "あ")
+	}
+}
@@ -1,25 +1,58 @@
 package yarex
 
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
 type execer interface {
-	exec(str string, pos int, onSuccess func(MatchContext)) bool
+	// exec tries to match str from pos, and calls onSuccess for the leftmost
+	// match. If anchored is true, it only tries the match starting at pos.
+	exec(str string, pos int, anchored bool, onSuccess func(MatchContext)) bool
+}
+
+// nextStart returns the position to try matching next to p. As in regexp,
+// matching only starts at boundaries of UTF-8 characters, and each invalid
+// byte is regarded as a character by itself.
+func nextStart(str string, p int) int {
+	if p < len(str) && str[p] >= utf8.RuneSelf {
+		_, size := utf8.DecodeRuneInString(str[p:])
+		return p + size
+	}
+	return p + 1
 }
 
 type Regexp struct {
-	str string
-	exe execer
+	str  string
+	exe  execer
+	nsub int
 }
 
 func Compile(ptn string) (*Regexp, error) {
 	if r, ok := compiledRegexps[ptn]; ok {
-		return r, nil
+		copied := *r // Copy not to share the registered one, which Longest may modify.
+		return &copied, nil
 	}
 	ast, err := parse(ptn)
 	if err != nil {
 		return nil, err
 	}
+	return compileAst(ptn, ast), nil
+}
+
+// compileAst compiles ast parsed from ptn with the best available engine.
+func compileAst(ptn string, ast Ast) *Regexp {
 	ast = optimizeAst(ast)
-	op := opCompile(ast)
-	return &Regexp{ptn, opExecer{op}}, nil
+	var exe execer
+	if isOnePass(ast) {
+		exe = onePassExecer{newOnePassProg(ast)}
+	} else {
+		exe = newClosureExecer(ast, false)
+	}
+	if bp := newBitParallelExecer(ast, exe); bp != nil {
+		exe = bp
+	}
+	return &Regexp{ptn, exe, numSubexp(ast)}
 }
 
 func MustCompile(ptn string) *Regexp {
@@ -30,25 +63,168 @@ func MustCompile(ptn string) *Regexp {
 	return r
 }
 
+// CompileLatin1 compiles ptn in byte mode, where the string to match is
+// regarded as Latin-1 rather than UTF-8. That is, '.' and char classes match
+// any single byte, and each character in ptn matches the byte of the same
+// value, e.g. `[\200-\377]` matches bytes from 0x80 to 0xFF. It is useful for
+// binary data. ptn must not contain characters above U+00FF.
+func CompileLatin1(ptn string) (*Regexp, error) {
+	ast, err := parse(ptn)
+	if err != nil {
+		return nil, err
+	}
+	ast, err = latin1Ast(ast)
+	if err != nil {
+		return nil, err
+	}
+	return &Regexp{ptn, newClosureExecer(optimizeAst(ast), true), numSubexp(ast)}, nil
+}
+
+func MustCompileLatin1(ptn string) *Regexp {
+	r, err := CompileLatin1(ptn)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
 func (re Regexp) String() string {
 	return re.str
 }
 
+// SubexpNames returns the names of the capturing groups in re, where names[i]
+// is the name of the i-th group. As in regexp, names[0] is for the entire
+// match, and the names of unnamed groups are empty.
+func (re Regexp) SubexpNames() []string {
+	ast, err := parse(re.str)
+	if err != nil {
+		panic(fmt.Errorf("(THIS SHOULD NOT HAPPEN) can't parse compiled regexp: %w", err))
+	}
+	return appendCaptureNames([]string{""}, ast)
+}
+
+// appendCaptureNames appends the names of capturing groups in re to names in
+// the order of their indices.
+func appendCaptureNames(names []string, re Ast) []string {
+	switch r := re.(type) {
+	case *AstCap:
+		return appendCaptureNames(append(names, r.name), r.re)
+	case *AstRepeat:
+		return appendCaptureNames(names, r.re)
+	case *AstSeq:
+		for _, s := range r.seq {
+			names = appendCaptureNames(names, s)
+		}
+	case *AstAlt:
+		for _, o := range r.opts {
+			names = appendCaptureNames(names, o)
+		}
+	}
+	return names
+}
+
+// NumSubexp returns the number of capturing groups in re.
+func (re Regexp) NumSubexp() int {
+	return re.nsub
+}
+
+// numSubexp returns the number of capturing groups in re.
+func numSubexp(re Ast) int {
+	return len(appendCaptureNames(nil, re))
+}
+
+// mustNumSubexp is numSubexp for the pattern given to compiled matchers, which
+// must have been parsed successfully by yarexgen.
+func mustNumSubexp(ptn string) int {
+	ast, err := parse(ptn)
+	if err != nil {
+		panic(fmt.Errorf("(THIS SHOULD NOT HAPPEN) can't parse compiled regexp: %w", err))
+	}
+	return numSubexp(ast)
+}
+
 func (re Regexp) MatchString(s string) bool {
-	return re.exe.exec(s, 0, func(_ MatchContext) {})
+	if m, ok := re.exe.(matcher); ok {
+		return m.match(s)
+	}
+	return re.exe.exec(s, 0, false, func(_ MatchContext) {})
 }
 
 func (re Regexp) FindString(s string) string {
 	matched := ""
-	re.exe.exec(s, 0, func(c MatchContext) {
+	re.exe.exec(s, 0, false, func(c MatchContext) {
 		matched, _ = c.GetCaptured(ContextKey{'c', 0})
 	})
 	return matched
 }
 
 func (re Regexp) FindStringIndex(s string) (loc []int) {
-	re.exe.exec(s, 0, func(c MatchContext) {
+	re.exe.exec(s, 0, false, func(c MatchContext) {
+		loc = c.GetCapturedIndex(ContextKey{'c', 0})
+	})
+	return loc
+}
+
+// MatchStringAt reports whether re matches s at pos, i.e. the match starts
+// exactly at pos. It does not scan s for a match starting after pos.
+// Note that ^ matches only at the beginning of s, not at pos.
+func (re Regexp) MatchStringAt(s string, pos int) bool {
+	if pos < 0 || len(s) < pos {
+		return false
+	}
+	return re.exe.exec(s, pos, true, func(_ MatchContext) {})
+}
+
+// FindStringAt returns the text of the match of re starting exactly at pos in
+// s. It returns empty string if there is no match, but it also returns empty
+// string if re matches empty string. Use FindStringIndexAt to distinguish them.
+func (re Regexp) FindStringAt(s string, pos int) string {
+	if loc := re.FindStringIndexAt(s, pos); loc != nil {
+		return s[loc[0]:loc[1]]
+	}
+	return ""
+}
+
+// FindStringIndexAt returns the location of the match of re starting exactly at
+// pos in s, or nil if there is no match. The location is the indices in s,
+// i.e. loc[0] is always pos.
+func (re Regexp) FindStringIndexAt(s string, pos int) (loc []int) {
+	if pos < 0 || len(s) < pos {
+		return nil
+	}
+	re.exe.exec(s, pos, true, func(c MatchContext) {
 		loc = c.GetCapturedIndex(ContextKey{'c', 0})
 	})
 	return loc
 }
+
+// FindStringSubmatchIndexAt is like FindStringIndexAt, but also returns the
+// locations of the submatches as FindStringSubmatchIndex of regexp, i.e.
+// loc[2*i:2*i+2] is the location of the i-th group, or -1s if it is unmatched.
+func (re Regexp) FindStringSubmatchIndexAt(s string, pos int) (loc []int) {
+	if pos < 0 || len(s) < pos {
+		return nil
+	}
+	re.exe.exec(s, pos, true, func(c MatchContext) {
+		loc = make([]int, 0, 2*(re.nsub+1))
+		for i := 0; i <= re.nsub; i++ {
+			if l := c.GetCapturedIndex(ContextKey{'c', uint(i)}); l != nil {
+				loc = append(loc, l...)
+			} else {
+				loc = append(loc, -1, -1)
+			}
+		}
+	})
+	return loc
+}
+
+// FullMatchString reports whether re matches the whole of s, as if re were
+// enclosed by ^(?: and )$.
+func (re Regexp) FullMatchString(s string) bool {
+	full := false
+	// The longest match is the whole string if any match is.
+	longestExecer(re.exe).exec(s, 0, true, func(c MatchContext) {
+		full = c.GetCapturedIndex(ContextKey{'c', 0})[1] == len(s)
+	})
+	return full
+}